@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-io/go-utils/stringutil"
+	"github.com/bitrise-tools/go-steputils/output"
+	"github.com/bitrise-tools/go-steputils/tools"
+	"github.com/bitrise-tools/go-xcode/xcodebuild"
+)
+
+const (
+	bitriseArchiveIndexPathEnvKey = "BITRISE_ARCHIVE_INDEX_PATH"
+
+	// archiveJobOutputPathEnvKey is set for the step processes archiving a single scheme of a multi-scheme run,
+	// they write their exported outputs to this path.
+	archiveJobOutputPathEnvKey = "BITRISE_ARCHIVE_JOB_OUTPUT_PATH"
+	envstorePathEnvKey         = "ENVMAN_ENVSTORE_PATH"
+
+	archiveJobStatusSucceeded = "succeeded"
+	archiveJobStatusFailed    = "failed"
+	archiveJobStatusTimedOut  = "timed_out"
+
+	archiveJobLogTailLines = 20
+
+	// archiveJobTerminateGracePeriod is the grace period of the jobs' step processes, which terminate their own
+	// xcodebuild commands with the xcodebuild.TerminateGracePeriod, before they exit.
+	archiveJobTerminateGracePeriod = 10 * time.Second
+)
+
+// archiveJob is a scheme - configuration pair of a multi-scheme run.
+type archiveJob struct {
+	Scheme        string
+	Configuration string
+	ArtifactName  string
+}
+
+// archiveJobOutput is written by the step process of an archive job, after the outputs are exported.
+type archiveJobOutput struct {
+	Outputs  exportedPathOutputs `json:"outputs"`
+	Metadata archiveMetadata     `json:"metadata"`
+	Cache    buildCache          `json:"cache"`
+}
+
+// archiveJobResult is an entry of the archive index.
+type archiveJobResult struct {
+	Scheme        string              `json:"scheme"`
+	Configuration string              `json:"configuration"`
+	ArtifactName  string              `json:"artifact_name"`
+	Status        string              `json:"status"`
+	Error         string              `json:"error,omitempty"`
+	LogPath       string              `json:"log_path"`
+	Artifacts     exportedPathOutputs `json:"artifacts,omitempty"`
+	Metadata      *archiveMetadata    `json:"metadata,omitempty"`
+	Cache         buildCache          `json:"-"`
+}
+
+// splitListInput splits the newline separated input value, the empty lines are dropped.
+func splitListInput(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, "\n") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// newArchiveJobs pairs the schemes with the configurations: a single configuration (or none) applies to every scheme,
+// otherwise the configurations are paired with the schemes in order.
+func newArchiveJobs(configs ConfigsModel) ([]archiveJob, error) {
+	schemes := splitListInput(configs.Scheme)
+	configurations := splitListInput(configs.Configuration)
+
+	if len(schemes) < 2 {
+		if len(configurations) > 1 {
+			return nil, fmt.Errorf("Configuration - multiple configurations (%d) set for a single scheme", len(configurations))
+		}
+		return nil, nil
+	}
+	if len(configurations) > 1 && len(configurations) != len(schemes) {
+		return nil, fmt.Errorf("Configuration - %d configurations set for %d schemes, set a single configuration or one for each scheme", len(configurations), len(schemes))
+	}
+
+	schemeCount := map[string]int{}
+	for _, scheme := range schemes {
+		schemeCount[scheme]++
+	}
+
+	// the artifact_name input defaults to the scheme input's (multi-line) value
+	artifactNamePrefix := ""
+	if configs.ArtifactName != "" && configs.ArtifactName != configs.Scheme {
+		artifactNamePrefix = configs.ArtifactName + "-"
+	}
+
+	jobs := []archiveJob{}
+	artifactNames := map[string]bool{}
+	for i, scheme := range schemes {
+		configuration := ""
+		if len(configurations) == 1 {
+			configuration = configurations[0]
+		} else if len(configurations) > 1 {
+			configuration = configurations[i]
+		}
+
+		artifactName := artifactNamePrefix + scheme
+		if schemeCount[scheme] > 1 {
+			if configuration == "" {
+				return nil, fmt.Errorf("Scheme - %s is set multiple times without configurations", scheme)
+			}
+			artifactName += "-" + configuration
+		}
+		if artifactNames[artifactName] {
+			return nil, fmt.Errorf("Scheme - %s (%s) is set multiple times", scheme, configuration)
+		}
+		artifactNames[artifactName] = true
+
+		jobs = append(jobs, archiveJob{
+			Scheme:        scheme,
+			Configuration: configuration,
+			ArtifactName:  artifactName,
+		})
+	}
+	return jobs, nil
+}
+
+// archiveJobRunner runs the step (as a separate process) for each archive job,
+// each job has its own output directory, log, envstore and (if run in parallel) DerivedData directory.
+type archiveJobRunner struct {
+	ctx            context.Context
+	configs        ConfigsModel
+	stepPath       string
+	concurrency    int
+	logMutex       sync.Mutex
+	jobsTmpDirPath string
+}
+
+func (runner *archiveJobRunner) jobEnvs(job archiveJob, jobOutputDir, jobTmpDir string) []string {
+	envs := []string{
+		"scheme=" + job.Scheme,
+		"configuration=" + job.Configuration,
+		"artifact_name=" + job.ArtifactName,
+		"output_dir=" + jobOutputDir,
+		"max_concurrent_archives=1",
+		envstorePathEnvKey + "=" + filepath.Join(jobTmpDir, ".envstore.yml"),
+		archiveJobOutputPathEnvKey + "=" + filepath.Join(jobTmpDir, "output.json"),
+	}
+
+	if runner.concurrency > 1 {
+		// parallel xcodebuild processes can not share the build database
+		derivedDataPath := filepath.Join(jobTmpDir, "DerivedData")
+		if runner.configs.DerivedDataPath != "" {
+			derivedDataPath = filepath.Join(runner.configs.DerivedDataPath, job.ArtifactName)
+		}
+		envs = append(envs, "derived_data_path="+derivedDataPath)
+
+		if runner.configs.IsCacheSwiftPackages == "yes" && runner.configs.ClonedSourcePackagesPath != "" {
+			envs = append(envs, "cloned_source_packages_path="+filepath.Join(runner.configs.ClonedSourcePackagesPath, job.ArtifactName))
+		}
+	}
+
+	return envs
+}
+
+func (runner *archiveJobRunner) printf(format string, v ...interface{}) {
+	runner.logMutex.Lock()
+	defer runner.logMutex.Unlock()
+	log.Printf(format, v...)
+}
+
+func (runner *archiveJobRunner) run(job archiveJob) archiveJobResult {
+	result := archiveJobResult{
+		Scheme:        job.Scheme,
+		Configuration: job.Configuration,
+		ArtifactName:  job.ArtifactName,
+		Status:        archiveJobStatusFailed,
+	}
+
+	fail := func(format string, v ...interface{}) archiveJobResult {
+		result.Error = fmt.Sprintf(format, v...)
+		return result
+	}
+
+	jobOutputDir := filepath.Join(runner.configs.OutputDir, job.ArtifactName)
+	if err := os.MkdirAll(jobOutputDir, 0777); err != nil {
+		return fail("failed to create output dir (%s), error: %s", jobOutputDir, err)
+	}
+	jobTmpDir := filepath.Join(runner.jobsTmpDirPath, job.ArtifactName)
+	if err := os.MkdirAll(jobTmpDir, 0777); err != nil {
+		return fail("failed to create tmp dir (%s), error: %s", jobTmpDir, err)
+	}
+	envs := runner.jobEnvs(job, jobOutputDir, jobTmpDir)
+	if err := fileutil.WriteStringToFile(filepath.Join(jobTmpDir, ".envstore.yml"), ""); err != nil {
+		return fail("failed to create envstore, error: %s", err)
+	}
+
+	result.LogPath = filepath.Join(jobOutputDir, job.ArtifactName+".log")
+	logFile, err := os.Create(result.LogPath)
+	if err != nil {
+		return fail("failed to create log file (%s), error: %s", result.LogPath, err)
+	}
+	defer func() {
+		if err := logFile.Close(); err != nil {
+			log.Warnf("Failed to close log file (%s), error: %s", result.LogPath, err)
+		}
+	}()
+
+	var out io.Writer = logFile
+	if runner.concurrency == 1 {
+		out = io.MultiWriter(os.Stdout, logFile)
+	}
+
+	runner.printf("Archiving %s (%s), log: %s", job.Scheme, orDash(job.Configuration), result.LogPath)
+
+	cmd := command.New(runner.stepPath)
+	cmd.SetEnvs(append(os.Environ(), envs...)...)
+	cmd.SetStdout(out)
+	cmd.SetStderr(out)
+	// the job's step process terminates its own xcodebuild commands, if it is interrupted
+	if err := xcodebuild.RunCommandWithGracePeriod(runner.ctx, cmd, xcodebuild.TerminateGracePeriod+archiveJobTerminateGracePeriod); err != nil {
+		if runner.concurrency > 1 {
+			if content, readErr := fileutil.ReadStringFromFile(result.LogPath); readErr == nil {
+				runner.logMutex.Lock()
+				log.Errorf("Last lines of the %s archive log:", job.Scheme)
+				fmt.Println(stringutil.LastNLines(content, archiveJobLogTailLines))
+				runner.logMutex.Unlock()
+			}
+		}
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == timedOutExitCode {
+			result.Status = archiveJobStatusTimedOut
+			return fail("archive timed out, error: %s", err)
+		}
+		return fail("archive failed, error: %s", err)
+	}
+
+	content, err := fileutil.ReadBytesFromFile(filepath.Join(jobTmpDir, "output.json"))
+	if err != nil {
+		return fail("failed to read the job's outputs, error: %s", err)
+	}
+	var jobOutput archiveJobOutput
+	if err := json.Unmarshal(content, &jobOutput); err != nil {
+		return fail("failed to parse the job's outputs, error: %s", err)
+	}
+
+	result.Status = archiveJobStatusSucceeded
+	result.Artifacts = jobOutput.Outputs
+	result.Metadata = &jobOutput.Metadata
+	result.Cache = jobOutput.Cache
+
+	runner.printf("%s (%s) archived", job.Scheme, orDash(job.Configuration))
+	return result
+}
+
+// archiveJobsError is returned if any of the archive jobs failed, timedOut is set if any of them timed out.
+type archiveJobsError struct {
+	failed   []string
+	jobCount int
+	timedOut bool
+}
+
+func (err archiveJobsError) Error() string {
+	return fmt.Sprintf("%d of %d archives failed:\n%s", len(err.failed), err.jobCount, strings.Join(err.failed, "\n"))
+}
+
+// runArchiveJobs archives the schemes (with at most the configured number of parallel archives)
+// and writes the archive index, mapping the artifact names to the exported outputs.
+// The step's outputs are exported from the first job's outputs.
+func runArchiveJobs(ctx context.Context, configs ConfigsModel, jobs []archiveJob) error {
+	concurrency, err := strconv.Atoi(configs.MaxConcurrentArchives)
+	if err != nil {
+		return fmt.Errorf("invalid MaxConcurrentArchives (%s), error: %s", configs.MaxConcurrentArchives, err)
+	}
+	if concurrency > len(jobs) {
+		concurrency = len(jobs)
+	}
+
+	if concurrency > 1 {
+		// the managed options are validated by the jobs' step processes, with the Xcode version specific -resultBundlePath
+		customOptions, err := parseXcodebuildOptions(configs.XcodebuildOptions, false)
+		if err != nil {
+			return fmt.Errorf("invalid XcodebuildOptions (%s), error: %s", configs.XcodebuildOptions, err)
+		}
+		if customOptionValue(customOptions, "-derivedDataPath") != "" {
+			return fmt.Errorf("-derivedDataPath is set by XcodebuildOptions, the parallel archives need separate DerivedData directories: use the DerivedDataPath input")
+		}
+	}
+
+	stepPath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the step's executable, error: %s", err)
+	}
+
+	if err := os.MkdirAll(configs.OutputDir, 0777); err != nil {
+		return fmt.Errorf("failed to create OutputDir (%s), error: %s", configs.OutputDir, err)
+	}
+
+	jobsTmpDirPath, err := pathutil.NormalizedOSTempDirPath("bitrise-archive-jobs")
+	if err != nil {
+		return fmt.Errorf("failed to create tmp dir, error: %s", err)
+	}
+
+	runner := &archiveJobRunner{
+		ctx:            ctx,
+		configs:        configs,
+		stepPath:       stepPath,
+		concurrency:    concurrency,
+		jobsTmpDirPath: jobsTmpDirPath,
+	}
+
+	fmt.Println()
+	log.Infof("Archiving %d schemes, %d at a time ...", len(jobs), concurrency)
+	for _, job := range jobs {
+		log.Printf("- %s: %s (%s)", job.ArtifactName, job.Scheme, orDash(job.Configuration))
+	}
+	fmt.Println()
+
+	results := make([]archiveJobResult, len(jobs))
+	semaphore := make(chan bool, concurrency)
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		wg.Add(1)
+		semaphore <- true
+		go func(i int, job archiveJob) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			results[i] = runner.run(job)
+		}(i, job)
+	}
+	wg.Wait()
+
+	index := map[string]archiveJobResult{}
+	failed := []string{}
+	timedOut := false
+	for _, result := range results {
+		index[result.ArtifactName] = result
+		if result.Status != archiveJobStatusSucceeded {
+			failed = append(failed, fmt.Sprintf("%s: %s (log: %s)", result.ArtifactName, result.Error, result.LogPath))
+		}
+		timedOut = timedOut || result.Status == archiveJobStatusTimedOut
+	}
+
+	fmt.Println()
+	indexPath := filepath.Join(configs.OutputDir, "archive-index.json")
+	content, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize the archive index, error: %s", err)
+	}
+	if err := output.ExportOutputFileContent(string(content)+"\n", indexPath, bitriseArchiveIndexPathEnvKey); err != nil {
+		return fmt.Errorf("failed to export %s, error: %s", bitriseArchiveIndexPathEnvKey, err)
+	}
+	log.Donef("The archive index path is now available in the Environment Variable: %s (value: %s)", bitriseArchiveIndexPathEnvKey, indexPath)
+
+	// the jobs' envstores are not passed to the next steps, the paths they cache are registered here
+	if jobsCache := archiveJobsCache(results); len(jobsCache.Include) > 0 {
+		if err := jobsCache.commit(); err != nil {
+			log.Warnf("Failed to register the archive jobs' cached paths in the build cache, error: %s", err)
+		} else {
+			log.Donef("The archive jobs' cached paths added to the build cache:")
+			for _, pth := range jobsCache.Include {
+				log.Printf("- %s", pth)
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		return archiveJobsError{failed: failed, jobCount: len(jobs), timedOut: timedOut}
+	}
+
+	first := results[0]
+	fmt.Println()
+	log.Infof("Exporting the outputs of %s ...", first.ArtifactName)
+	for _, key := range first.Artifacts.keys() {
+		if err := tools.ExportEnvironmentWithEnvman(key, first.Artifacts[key]); err != nil {
+			return fmt.Errorf("failed to export %s, error: %s", key, err)
+		}
+		log.Printf("- %s: %s", key, first.Artifacts[key])
+	}
+	if first.Metadata != nil {
+		if err := first.Metadata.export(); err != nil {
+			return fmt.Errorf("failed to export the archive's metadata, error: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// archiveJobsCache merges the paths cached by the succeeded archive jobs.
+func archiveJobsCache(results []archiveJobResult) buildCache {
+	jobsCache := buildCache{}
+	for _, result := range results {
+		if result.Status == archiveJobStatusSucceeded {
+			jobsCache.add(result.Cache.Include, result.Cache.Exclude)
+		}
+	}
+	return jobsCache
+}
+
+// writeArchiveJobOutput writes the outputs and the paths to cache of an archive job, if the step runs as one.
+func writeArchiveJobOutput(outputs exportedPathOutputs, metadata archiveMetadata, jobCache buildCache) error {
+	pth := os.Getenv(archiveJobOutputPathEnvKey)
+	if pth == "" {
+		return nil
+	}
+
+	content, err := json.Marshal(archiveJobOutput{Outputs: outputs, Metadata: metadata, Cache: jobCache})
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteBytesToFile(pth, content)
+}
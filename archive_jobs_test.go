@@ -0,0 +1,195 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestSplitListInput(t *testing.T) {
+	for _, tt := range []struct {
+		value string
+		want  []string
+	}{
+		{value: "", want: []string{}},
+		{value: "\n \n", want: []string{}},
+		{value: "App", want: []string{"App"}},
+		{value: "App\nHelper", want: []string{"App", "Helper"}},
+		{value: "  App \n\n\tHelper\t\r\n", want: []string{"App", "Helper"}},
+		{value: "My App\nMy Helper", want: []string{"My App", "My Helper"}},
+	} {
+		if got := splitListInput(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitListInput(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestNewArchiveJobs(t *testing.T) {
+	t.Log("single scheme")
+	{
+		for _, configs := range []ConfigsModel{
+			{Scheme: "App"},
+			{Scheme: "App", Configuration: "Release"},
+			{Scheme: "App\n", Configuration: "\nRelease\n"},
+		} {
+			jobs, err := newArchiveJobs(configs)
+			if err != nil {
+				t.Errorf("unexpected error for %+v: %s", configs, err)
+			}
+			if jobs != nil {
+				t.Errorf("unexpected jobs for %+v: %+v", configs, jobs)
+			}
+		}
+	}
+
+	t.Log("a single configuration applies to every scheme")
+	{
+		jobs, err := newArchiveJobs(ConfigsModel{Scheme: "App\nHelper", Configuration: "Release", ArtifactName: "App\nHelper"})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		want := []archiveJob{
+			{Scheme: "App", Configuration: "Release", ArtifactName: "App"},
+			{Scheme: "Helper", Configuration: "Release", ArtifactName: "Helper"},
+		}
+		if !reflect.DeepEqual(jobs, want) {
+			t.Errorf("got %+v, want %+v", jobs, want)
+		}
+	}
+
+	t.Log("configurations are paired with the schemes in order")
+	{
+		jobs, err := newArchiveJobs(ConfigsModel{Scheme: "App\nHelper", Configuration: "Release\nDebug"})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		want := []archiveJob{
+			{Scheme: "App", Configuration: "Release", ArtifactName: "App"},
+			{Scheme: "Helper", Configuration: "Debug", ArtifactName: "Helper"},
+		}
+		if !reflect.DeepEqual(jobs, want) {
+			t.Errorf("got %+v, want %+v", jobs, want)
+		}
+	}
+
+	t.Log("the artifact name prefixes the schemes")
+	{
+		jobs, err := newArchiveJobs(ConfigsModel{Scheme: "App\nHelper", ArtifactName: "Studio"})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		want := []archiveJob{
+			{Scheme: "App", ArtifactName: "Studio-App"},
+			{Scheme: "Helper", ArtifactName: "Studio-Helper"},
+		}
+		if !reflect.DeepEqual(jobs, want) {
+			t.Errorf("got %+v, want %+v", jobs, want)
+		}
+	}
+
+	t.Log("a scheme archived with multiple configurations")
+	{
+		jobs, err := newArchiveJobs(ConfigsModel{Scheme: "App\nApp", Configuration: "Release\nBeta"})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		want := []archiveJob{
+			{Scheme: "App", Configuration: "Release", ArtifactName: "App-Release"},
+			{Scheme: "App", Configuration: "Beta", ArtifactName: "App-Beta"},
+		}
+		if !reflect.DeepEqual(jobs, want) {
+			t.Errorf("got %+v, want %+v", jobs, want)
+		}
+	}
+
+	t.Log("invalid scheme - configuration pairs")
+	{
+		for _, configs := range []ConfigsModel{
+			// multiple configurations for a single scheme
+			{Scheme: "App", Configuration: "Release\nDebug"},
+			// configuration count does not match the scheme count
+			{Scheme: "App\nHelper\nTool", Configuration: "Release\nDebug"},
+			// duplicated scheme without configurations
+			{Scheme: "App\nApp"},
+			// duplicated scheme with the same configuration
+			{Scheme: "App\nApp", Configuration: "Release"},
+			{Scheme: "App\nHelper\nApp", Configuration: "Release\nDebug\nRelease"},
+		} {
+			if jobs, err := newArchiveJobs(configs); err == nil {
+				t.Errorf("expected error for %+v, got jobs: %+v", configs, jobs)
+			}
+		}
+	}
+}
+
+func TestArchiveJobsCache(t *testing.T) {
+	t.Log("archive jobs only collect the cached paths")
+	{
+		origJobOutputPath, isSet := os.LookupEnv(archiveJobOutputPathEnvKey)
+		if err := os.Setenv(archiveJobOutputPathEnvKey, "output.json"); err != nil {
+			t.Fatalf("failed to set %s: %s", archiveJobOutputPathEnvKey, err)
+		}
+		defer func() {
+			restore := func() error { return os.Unsetenv(archiveJobOutputPathEnvKey) }
+			if isSet {
+				restore = func() error { return os.Setenv(archiveJobOutputPathEnvKey, origJobOutputPath) }
+			}
+			if err := restore(); err != nil {
+				t.Logf("failed to restore %s: %s", archiveJobOutputPathEnvKey, err)
+			}
+		}()
+
+		jobCache := buildCache{}
+		if err := jobCache.register([]string{"/tmp/SourcePackages/App -> /tmp/App.xcworkspace/xcshareddata/swiftpm/Package.resolved"}, nil); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := cacheDerivedData("/tmp/DerivedData/App", &jobCache); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		want := buildCache{
+			Include: []string{"/tmp/SourcePackages/App -> /tmp/App.xcworkspace/xcshareddata/swiftpm/Package.resolved", "/tmp/DerivedData/App"},
+			Exclude: []string{
+				"/tmp/DerivedData/App/ModuleCache",
+				"/tmp/DerivedData/App/ModuleCache.noindex",
+				"/tmp/DerivedData/App/Index",
+				"/tmp/DerivedData/App/Index.noindex",
+				"/tmp/DerivedData/App/Logs",
+				"/tmp/DerivedData/App/SourcePackages",
+			},
+		}
+		if !reflect.DeepEqual(jobCache, want) {
+			t.Errorf("got %+v, want %+v", jobCache, want)
+		}
+	}
+
+	t.Log("the succeeded jobs' cached paths are merged")
+	{
+		results := []archiveJobResult{
+			{
+				Status: archiveJobStatusSucceeded,
+				Cache:  buildCache{Include: []string{"/tmp/SourcePackages -> Package.resolved", "/tmp/DerivedData/App"}, Exclude: []string{"/tmp/DerivedData/App/Index"}},
+			},
+			{
+				Status: archiveJobStatusFailed,
+				Cache:  buildCache{Include: []string{"/tmp/DerivedData/Failed"}},
+			},
+			{
+				Status: archiveJobStatusSucceeded,
+				Cache:  buildCache{Include: []string{"/tmp/SourcePackages -> Package.resolved", "/tmp/DerivedData/Helper"}, Exclude: []string{"/tmp/DerivedData/Helper/Index"}},
+			},
+		}
+
+		want := buildCache{
+			Include: []string{"/tmp/SourcePackages -> Package.resolved", "/tmp/DerivedData/App", "/tmp/DerivedData/Helper"},
+			Exclude: []string{"/tmp/DerivedData/App/Index", "/tmp/DerivedData/Helper/Index"},
+		}
+		if got := archiveJobsCache(results); !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+
+		if got := archiveJobsCache([]archiveJobResult{{Status: archiveJobStatusFailed}}); len(got.Include) != 0 || len(got.Exclude) != 0 {
+			t.Errorf("unexpected cache: %+v", got)
+		}
+	}
+}
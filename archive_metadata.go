@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-tools/go-steputils/output"
+	"github.com/bitrise-tools/go-steputils/tools"
+	"github.com/bitrise-tools/go-xcode/models"
+	"github.com/bitrise-tools/go-xcode/plistutil"
+	"github.com/bitrise-tools/go-xcode/xcarchive"
+)
+
+const (
+	bitriseAppBundleIDEnvKey        = "BITRISE_APP_BUNDLE_ID"
+	bitriseAppVersionEnvKey         = "BITRISE_APP_VERSION"
+	bitriseAppBuildNumberEnvKey     = "BITRISE_APP_BUILD_NUMBER"
+	bitriseAppMinMacosVersionEnvKey = "BITRISE_APP_MIN_MACOS_VERSION"
+	bitriseAppTeamIDEnvKey          = "BITRISE_APP_TEAM_ID"
+	bitriseAppSigningIdentityEnvKey = "BITRISE_APP_SIGNING_IDENTITY"
+	bitriseExportMethodEnvKey       = "BITRISE_EXPORT_METHOD"
+
+	bitriseXCArchiveManifestPthEnvKey = "BITRISE_XCARCHIVE_MANIFEST_PATH"
+)
+
+// archiveMetadata is the archived app's metadata, exported as step outputs.
+type archiveMetadata struct {
+	BundleID        string `json:"bundle_id"`
+	Version         string `json:"version"`
+	BuildNumber     string `json:"build_number"`
+	MinMacosVersion string `json:"min_macos_version"`
+	TeamID          string `json:"team_id"`
+	SigningIdentity string `json:"signing_identity"`
+	ExportMethod    string `json:"export_method"`
+}
+
+// usedExportMethod returns the method of the custom export options, if set, otherwise the export_method input
+// (the comma separated list of the methods, if multiple methods are set).
+func usedExportMethod(configs ConfigsModel) string {
+	inputMethod := strings.Join(exportMethods(configs), ",")
+	if configs.CustomExportOptionsPlistContent == "" {
+		return inputMethod
+	}
+
+	exportOptions, err := plistutil.NewPlistDataFromContent(configs.CustomExportOptionsPlistContent)
+	if err != nil {
+		log.Warnf("Failed to parse CustomExportOptionsPlistContent, error: %s", err)
+		return inputMethod
+	}
+	if method, ok := exportOptions.GetString("method"); ok && method != "" {
+		return method
+	}
+	return inputMethod
+}
+
+// newArchiveMetadata reads the metadata of the archive's main application.
+func newArchiveMetadata(archive xcarchive.MacosArchive, exportMethod string) archiveMetadata {
+	return archiveMetadata{
+		BundleID:        archive.Application.BundleIdentifier(),
+		Version:         archive.Application.Version(),
+		BuildNumber:     archive.Application.BuildNumber(),
+		MinMacosVersion: archive.Application.MinimumSystemVersion(),
+		TeamID:          archive.TeamID(),
+		SigningIdentity: archive.SigningIdentity(),
+		ExportMethod:    exportMethod,
+	}
+}
+
+// envs maps the output environment variable keys to the metadata values.
+func (metadata archiveMetadata) envs() [][2]string {
+	return [][2]string{
+		{bitriseAppBundleIDEnvKey, metadata.BundleID},
+		{bitriseAppVersionEnvKey, metadata.Version},
+		{bitriseAppBuildNumberEnvKey, metadata.BuildNumber},
+		{bitriseAppMinMacosVersionEnvKey, metadata.MinMacosVersion},
+		{bitriseAppTeamIDEnvKey, metadata.TeamID},
+		{bitriseAppSigningIdentityEnvKey, metadata.SigningIdentity},
+		{bitriseExportMethodEnvKey, metadata.ExportMethod},
+	}
+}
+
+// export exports the metadata as environment variables, the empty values are exported as well,
+// so the outputs of a previous step run do not leak into the next steps.
+func (metadata archiveMetadata) export() error {
+	for _, env := range metadata.envs() {
+		if err := tools.ExportEnvironmentWithEnvman(env[0], env[1]); err != nil {
+			return fmt.Errorf("failed to export %s, error: %s", env[0], err)
+		}
+		log.Printf("- %s: %s", env[0], env[1])
+	}
+	return nil
+}
+
+// archiveManifest describes the archive of an archive-only run, for the steps exporting it.
+type archiveManifest struct {
+	ArchivePath       string          `json:"archive_path"`
+	ArchiveZipPath    string          `json:"archive_zip_path,omitempty"`
+	Scheme            string          `json:"scheme"`
+	Configuration     string          `json:"configuration"`
+	XcodeVersion      string          `json:"xcode_version"`
+	XcodeBuildVersion string          `json:"xcode_build_version"`
+	Metadata          archiveMetadata `json:"metadata"`
+}
+
+// newArchiveManifest creates the manifest of the exported archive (and its zip, if exported).
+func newArchiveManifest(configs ConfigsModel, xcodebuildVersion models.XcodebuildVersionModel, metadata archiveMetadata, outputs exportedPathOutputs) archiveManifest {
+	return archiveManifest{
+		ArchivePath:       outputs[bitriseXCArchiveDirPthEnvKey],
+		ArchiveZipPath:    outputs[bitriseXCArchivePthEnvKey],
+		Scheme:            configs.Scheme,
+		Configuration:     configs.Configuration,
+		XcodeVersion:      xcodebuildVersion.Version,
+		XcodeBuildVersion: xcodebuildVersion.BuildVersion,
+		Metadata:          metadata,
+	}
+}
+
+// write writes the manifest as indented JSON.
+func (manifest archiveManifest) write(pth string) error {
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteBytesToFile(pth, append(content, '\n'))
+}
+
+// exportArchiveManifest writes the manifest of the archive-only run and exports its path.
+func exportArchiveManifest(configs ConfigsModel, xcodebuildVersion models.XcodebuildVersionModel, metadata archiveMetadata, outputs exportedPathOutputs, pth string) error {
+	manifest := newArchiveManifest(configs, xcodebuildVersion, metadata, outputs)
+	if err := manifest.write(pth); err != nil {
+		return fmt.Errorf("failed to write the archive manifest, error: %s", err)
+	}
+	if err := output.ExportOutputFile(pth, pth, bitriseXCArchiveManifestPthEnvKey); err != nil {
+		return fmt.Errorf("failed to export %s, error: %s", bitriseXCArchiveManifestPthEnvKey, err)
+	}
+	outputs[bitriseXCArchiveManifestPthEnvKey] = pth
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-tools/go-xcode/models"
+	"github.com/bitrise-tools/go-xcode/xcarchive"
+)
+
+func TestExportArchiveManifest(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Logf("failed to remove tmp dir: %s", err)
+		}
+	}()
+
+	_, envstorePth, restore := setupFakeEnvman(t, tmpDir)
+	defer restore()
+
+	archivePth := createTestArchive(t, tmpDir, "App", "42", "2.1.0")
+	archive, err := xcarchive.NewMacosArchive(archivePth)
+	if err != nil {
+		t.Fatalf("failed to open archive: %s", err)
+	}
+	// the export method is not known in archive-only mode
+	metadata := newArchiveMetadata(archive, "")
+
+	configs := ConfigsModel{Scheme: "App", Configuration: "Release"}
+	xcodebuildVersion := models.XcodebuildVersionModel{Version: "13.0", BuildVersion: "13A233"}
+
+	t.Log("archive with its zip")
+	{
+		if err := os.RemoveAll(envstorePth); err != nil {
+			t.Fatalf("failed to clean envstore: %s", err)
+		}
+
+		manifestPth := filepath.Join(tmpDir, "App.xcarchive.json")
+		outputs := exportedPathOutputs{
+			bitriseXCArchiveDirPthEnvKey: archivePth,
+			bitriseXCArchivePthEnvKey:    archivePth + ".zip",
+		}
+		if err := exportArchiveManifest(configs, xcodebuildVersion, metadata, outputs, manifestPth); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		content, err := ioutil.ReadFile(manifestPth)
+		if err != nil {
+			t.Fatalf("failed to read manifest: %s", err)
+		}
+		want := strings.Replace(testArchiveManifestContent, "ARCHIVE_PATH", archivePth, -1)
+		if string(content) != want {
+			t.Errorf("got manifest:\n%s\nwant:\n%s", content, want)
+		}
+
+		if outputs[bitriseXCArchiveManifestPthEnvKey] != manifestPth {
+			t.Errorf("manifest path is not added to the outputs: %v", outputs)
+		}
+		wantEnvs := []string{bitriseXCArchiveManifestPthEnvKey + "=" + manifestPth}
+		if envs := readFakeEnvstore(t, envstorePth); !reflect.DeepEqual(envs, wantEnvs) {
+			t.Errorf("got envs %q, want %q", envs, wantEnvs)
+		}
+	}
+
+	t.Log("archive without zip")
+	{
+		manifestPth := filepath.Join(tmpDir, "App-no-zip.xcarchive.json")
+		outputs := exportedPathOutputs{bitriseXCArchiveDirPthEnvKey: archivePth}
+		if err := exportArchiveManifest(configs, xcodebuildVersion, metadata, outputs, manifestPth); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		content, err := ioutil.ReadFile(manifestPth)
+		if err != nil {
+			t.Fatalf("failed to read manifest: %s", err)
+		}
+		want := strings.Replace(testArchiveManifestContent, "ARCHIVE_PATH", archivePth, -1)
+		want = strings.Replace(want, `  "archive_zip_path": "`+archivePth+`.zip",`+"\n", "", 1)
+		if string(content) != want {
+			t.Errorf("got manifest:\n%s\nwant:\n%s", content, want)
+		}
+	}
+
+	t.Log("manifest can not be written")
+	{
+		outputs := exportedPathOutputs{bitriseXCArchiveDirPthEnvKey: archivePth}
+		if err := exportArchiveManifest(configs, xcodebuildVersion, metadata, outputs, filepath.Join(archivePth, "Info.plist", "App.xcarchive.json")); err == nil {
+			t.Errorf("expected error for the invalid manifest path")
+		}
+		if _, ok := outputs[bitriseXCArchiveManifestPthEnvKey]; ok {
+			t.Errorf("manifest path is added to the outputs: %v", outputs)
+		}
+	}
+}
+
+const testArchiveManifestContent = `{
+  "archive_path": "ARCHIVE_PATH",
+  "archive_zip_path": "ARCHIVE_PATH.zip",
+  "scheme": "App",
+  "configuration": "Release",
+  "xcode_version": "13.0",
+  "xcode_build_version": "13A233",
+  "metadata": {
+    "bundle_id": "io.bitrise.app",
+    "version": "2.1.0",
+    "build_number": "42",
+    "min_macos_version": "10.15",
+    "team_id": "",
+    "signing_identity": "",
+    "export_method": ""
+  }
+}
+`
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+
+	"github.com/bitrise-io/go-utils/sliceutil"
+	"github.com/bitrise-tools/go-steputils/cache"
+)
+
+// buildCache collects the paths registered in the Bitrise build cache.
+// The cache registration appends to the cache environment variables of the step's start, so every registration
+// writes the paths collected so far. The step processes of the archive jobs have their own envstore,
+// they only collect the paths, which are registered by the parent process with the jobs' outputs.
+type buildCache struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// add adds the paths, which are not collected yet.
+func (c *buildCache) add(include, exclude []string) {
+	for _, pth := range include {
+		if !sliceutil.IsStringInSlice(pth, c.Include) {
+			c.Include = append(c.Include, pth)
+		}
+	}
+	for _, pth := range exclude {
+		if !sliceutil.IsStringInSlice(pth, c.Exclude) {
+			c.Exclude = append(c.Exclude, pth)
+		}
+	}
+}
+
+// register adds the paths and registers the collected paths in the build cache,
+// unless the step runs as an archive job.
+func (c *buildCache) register(include, exclude []string) error {
+	c.add(include, exclude)
+	if os.Getenv(archiveJobOutputPathEnvKey) != "" {
+		return nil
+	}
+	return c.commit()
+}
+
+// commit registers the collected paths in the build cache.
+func (c buildCache) commit() error {
+	pathCache := cache.New()
+	for _, pth := range c.Include {
+		pathCache.IncludePath(pth)
+	}
+	for _, pth := range c.Exclude {
+		pathCache.ExcludePath(pth)
+	}
+	return pathCache.Commit()
+}
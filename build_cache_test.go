@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-tools/go-steputils/cache"
+)
+
+func TestCacheDerivedData(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "build-cache")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Logf("failed to remove tmp dir: %s", err)
+		}
+	}()
+
+	_, envstorePth, restore := setupFakeEnvman(t, tmpDir)
+	defer restore()
+	defer setenvForTest(t, cache.GlobalCachePathsEnvironmentKey, "")()
+	defer setenvForTest(t, cache.GlobalCacheIgnorePathsEnvironmentKey, "")()
+	defer setenvForTest(t, archiveJobOutputPathEnvKey, "")()
+
+	derivedDataPath := filepath.Join(tmpDir, "DerivedData")
+
+	t.Log("DerivedData is registered without the excluded subdirectories")
+	{
+		stepCache := buildCache{}
+		if err := cacheDerivedData(derivedDataPath, &stepCache); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		content, err := ioutil.ReadFile(envstorePth)
+		if err != nil {
+			t.Fatalf("failed to read envstore: %s", err)
+		}
+		want := cache.GlobalCachePathsEnvironmentKey + "=\n" +
+			derivedDataPath + "\n" +
+			cache.GlobalCacheIgnorePathsEnvironmentKey + "=\n" +
+			derivedDataPath + "/ModuleCache\n" +
+			derivedDataPath + "/ModuleCache.noindex\n" +
+			derivedDataPath + "/Index\n" +
+			derivedDataPath + "/Index.noindex\n" +
+			derivedDataPath + "/Logs\n" +
+			derivedDataPath + "/SourcePackages\n"
+		if string(content) != want {
+			t.Errorf("got envstore:\n%s\nwant:\n%s", content, want)
+		}
+	}
+
+	t.Log("the archive jobs' DerivedData directories are registered by the parent process")
+	{
+		if err := os.Remove(envstorePth); err != nil {
+			t.Fatalf("failed to clean envstore: %s", err)
+		}
+
+		results := []archiveJobResult{}
+		for _, artifactName := range []string{"App", "Helper"} {
+			restoreJobOutputPath := setenvForTest(t, archiveJobOutputPathEnvKey, filepath.Join(tmpDir, artifactName, "output.json"))
+			jobCache := buildCache{}
+			err := cacheDerivedData(filepath.Join(derivedDataPath, artifactName), &jobCache)
+			restoreJobOutputPath()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			results = append(results, archiveJobResult{Status: archiveJobStatusSucceeded, Cache: jobCache})
+		}
+		if _, err := os.Stat(envstorePth); !os.IsNotExist(err) {
+			t.Errorf("archive jobs registered the cache, error: %v", err)
+		}
+
+		if err := archiveJobsCache(results).commit(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		content, err := ioutil.ReadFile(envstorePth)
+		if err != nil {
+			t.Fatalf("failed to read envstore: %s", err)
+		}
+		want := cache.GlobalCachePathsEnvironmentKey + "=\n" +
+			derivedDataPath + "/App\n" +
+			derivedDataPath + "/Helper\n" +
+			cache.GlobalCacheIgnorePathsEnvironmentKey + "=\n"
+		for _, artifactName := range []string{"App", "Helper"} {
+			for _, exclude := range []string{"ModuleCache", "ModuleCache.noindex", "Index", "Index.noindex", "Logs", "SourcePackages"} {
+				want += filepath.Join(derivedDataPath, artifactName, exclude) + "\n"
+			}
+		}
+		if string(content) != want {
+			t.Errorf("got envstore:\n%s\nwant:\n%s", content, want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// timedOutError is the error of a phase which did not finish in time.
+type timedOutError struct {
+	phase   string
+	timeout time.Duration
+}
+
+func (err timedOutError) Error() string {
+	return fmt.Sprintf("%s timed out after %s", err.phase, err.timeout)
+}
+
+// interruptContext returns a context, which is canceled if the step receives SIGINT or SIGTERM.
+// The running xcodebuild and xcpretty commands are terminated by the phases, the step fails after they exited.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		fmt.Println()
+		log.Warnf("Received %s, terminating the running commands ...", sig)
+		cancel()
+	}()
+
+	return ctx
+}
+
+// stepPhase is the archive or the export phase, its context is done if the phase timed out or the step is interrupted.
+type stepPhase struct {
+	name    string
+	timeout time.Duration
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// newStepPhase starts a phase, without timeout if the timeout is 0.
+func newStepPhase(parent context.Context, name string, timeout time.Duration) stepPhase {
+	phase := stepPhase{name: name, timeout: timeout}
+	if timeout > 0 {
+		phase.ctx, phase.cancel = context.WithTimeout(parent, timeout)
+	} else {
+		phase.ctx, phase.cancel = context.WithCancel(parent)
+	}
+	return phase
+}
+
+// isDone checks if the phase timed out or the step is interrupted.
+func (phase stepPhase) isDone() bool {
+	return phase.ctx.Err() != nil
+}
+
+// isTimedOut checks if the phase timed out.
+func (phase stepPhase) isTimedOut() bool {
+	return phase.ctx.Err() == context.DeadlineExceeded
+}
+
+// err replaces the error of a command, which was terminated because the phase timed out or the step is interrupted.
+func (phase stepPhase) err(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch phase.ctx.Err() {
+	case context.DeadlineExceeded:
+		return timedOutError{phase: phase.name, timeout: phase.timeout}
+	case context.Canceled:
+		return fmt.Errorf("%s interrupted", phase.name)
+	}
+	return err
+}
+
+// parseTimeout parses a (validated) timeout input in seconds.
+func parseTimeout(seconds string) time.Duration {
+	value, _ := strconv.Atoi(seconds)
+	return time.Duration(value) * time.Second
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-tools/go-xcode/certificateutil"
+	"github.com/bitrise-tools/go-xcode/exportoptions"
+	"github.com/bitrise-tools/go-xcode/profileutil"
+	"github.com/bitrise-tools/go-xcode/xcodeproj"
+	"github.com/ryanuber/go-glob"
+)
+
+const (
+	codeSignCheckFail = "fail"
+	codeSignCheckWarn = "warn"
+	codeSignCheckNone = "none"
+)
+
+// teamIDRegexp matches Developer Portal team IDs, like: 1MZX23ABCD
+var teamIDRegexp = regexp.MustCompile(`^[A-Z0-9]{10}$`)
+
+// equivalentIdentities are the code sign identity groups Xcode treats as interchangeable.
+var equivalentIdentities = [][]string{
+	{"Apple Development", "Mac Developer"},
+	{"Apple Distribution", "Mac Distribution", "3rd Party Mac Developer Application"},
+}
+
+// developmentCertificatePrefixes and developerIDCertificatePrefixes are the common name prefixes of
+// the certificates, which can sign a development or Developer ID export without a provisioning profile.
+var developmentCertificatePrefixes = []string{"Apple Development", "Mac Developer"}
+var developerIDCertificatePrefixes = []string{"Developer ID Application"}
+
+// codeSignCheck holds the installed (or supplied) certificates and profiles the project's signing settings are checked against.
+type codeSignCheck struct {
+	certificates []certificateutil.CertificateInfoModel
+	profiles     []profileutil.ProvisioningProfileInfoModel
+	exportMethod string
+
+	// allowProvisioningUpdates is set if xcodebuild may create or download the missing profiles
+	allowProvisioningUpdates bool
+}
+
+// targetCodeSignCheckResult is a row of the signing compatibility table.
+type targetCodeSignCheckResult struct {
+	Target   string
+	BundleID string
+	TeamID   string
+	Identity string
+	Profile  string
+	Errors   []string
+	Warnings []string
+}
+
+// splitProfileSpecifier splits the TEAM_ID/PROFILE_NAME format of the force_provisioning_profile_specifier input.
+func splitProfileSpecifier(specifier string) (string, string) {
+	if split := strings.SplitN(specifier, "/", 2); len(split) == 2 && teamIDRegexp.MatchString(split[0]) {
+		return split[0], split[1]
+	}
+	return "", specifier
+}
+
+// identityMatches returns true if the code sign identity (full name, name prefix or SHA1 fingerprint) selects the certificate.
+func identityMatches(identity string, certificate certificateutil.CertificateInfoModel) bool {
+	if identity == "" {
+		return true
+	}
+	if strings.EqualFold(identity, certificate.SHA1Fingerprint) {
+		return true
+	}
+	if certificate.CommonName == identity || strings.HasPrefix(certificate.CommonName, identity+":") {
+		return true
+	}
+
+	for _, group := range equivalentIdentities {
+		inGroup := false
+		for _, name := range group {
+			if identity == name {
+				inGroup = true
+				break
+			}
+		}
+		if !inGroup {
+			continue
+		}
+
+		for _, name := range group {
+			if strings.HasPrefix(certificate.CommonName, name+":") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func hasAnyPrefix(str string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(str, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchingCertificates returns the certificates selected by the identity, belonging to the team (if set).
+func (c codeSignCheck) matchingCertificates(identity, teamID string) []certificateutil.CertificateInfoModel {
+	certificates := []certificateutil.CertificateInfoModel{}
+	for _, certificate := range c.certificates {
+		if teamID != "" && certificate.TeamID != teamID {
+			continue
+		}
+		if identityMatches(identity, certificate) {
+			certificates = append(certificates, certificate)
+		}
+	}
+	return certificates
+}
+
+// findProfile returns the profile with the given UUID or name.
+func (c codeSignCheck) findProfile(uuid, specifier string) (profileutil.ProvisioningProfileInfoModel, bool) {
+	_, name := splitProfileSpecifier(specifier)
+	for _, profile := range c.profiles {
+		if uuid != "" && profile.UUID == uuid {
+			return profile, true
+		}
+		if uuid == "" && name != "" && profile.Name == name {
+			return profile, true
+		}
+	}
+	return profileutil.ProvisioningProfileInfoModel{}, false
+}
+
+// exportProfiles returns the valid profiles of the export method, which cover the bundle ID and have an installed certificate.
+func (c codeSignCheck) exportProfiles(bundleID, teamID string) []profileutil.ProvisioningProfileInfoModel {
+	profiles := []profileutil.ProvisioningProfileInfoModel{}
+	for _, profile := range c.profiles {
+		if string(profile.ExportType) != c.exportMethod {
+			continue
+		}
+		if teamID != "" && profile.TeamID != teamID {
+			continue
+		}
+		if !glob.Glob(profile.BundleID, bundleID) {
+			continue
+		}
+		if profile.CheckValidity() != nil || !profile.HasInstalledCertificate(c.certificates) {
+			continue
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles
+}
+
+// checkForcedInputs fails if the force_* inputs contradict each other.
+func (c codeSignCheck) checkForcedInputs(configs ConfigsModel) []string {
+	errors := []string{}
+
+	specifierTeamID, _ := splitProfileSpecifier(configs.ForceProvisioningProfileSpecifier)
+	if configs.ForceTeamID != "" && specifierTeamID != "" && specifierTeamID != configs.ForceTeamID {
+		errors = append(errors, fmt.Sprintf("force_provisioning_profile_specifier's team (%s) does not match force_team_id (%s)", specifierTeamID, configs.ForceTeamID))
+	}
+
+	var forcedCertificates []certificateutil.CertificateInfoModel
+	if configs.ForceCodeSignIdentity != "" {
+		forcedCertificates = c.matchingCertificates(configs.ForceCodeSignIdentity, configs.ForceTeamID)
+		if len(forcedCertificates) == 0 {
+			if configs.ForceTeamID != "" && len(c.matchingCertificates(configs.ForceCodeSignIdentity, "")) > 0 {
+				errors = append(errors, fmt.Sprintf("force_code_sign_identity (%s) does not select any certificate of force_team_id (%s)", configs.ForceCodeSignIdentity, configs.ForceTeamID))
+			} else {
+				errors = append(errors, fmt.Sprintf("no installed certificate matches force_code_sign_identity (%s)", configs.ForceCodeSignIdentity))
+			}
+		}
+	}
+
+	if configs.ForceProvisioningProfile == "" && configs.ForceProvisioningProfileSpecifier == "" {
+		return errors
+	}
+
+	forcedProfileName := configs.ForceProvisioningProfile
+	if forcedProfileName == "" {
+		forcedProfileName = configs.ForceProvisioningProfileSpecifier
+	}
+
+	profile, found := c.findProfile(configs.ForceProvisioningProfile, configs.ForceProvisioningProfileSpecifier)
+	if !found {
+		if !c.allowProvisioningUpdates {
+			errors = append(errors, fmt.Sprintf("forced provisioning profile (%s) is not installed", forcedProfileName))
+		}
+		return errors
+	}
+
+	if configs.ForceTeamID != "" && profile.TeamID != configs.ForceTeamID {
+		errors = append(errors, fmt.Sprintf("forced provisioning profile's team (%s) does not match force_team_id (%s)", profile.TeamID, configs.ForceTeamID))
+	}
+	if specifierTeamID != "" && profile.TeamID != specifierTeamID {
+		errors = append(errors, fmt.Sprintf("forced provisioning profile's team (%s) does not match force_provisioning_profile_specifier's team (%s)", profile.TeamID, specifierTeamID))
+	}
+
+	if len(forcedCertificates) > 0 {
+		profileContainsForcedCertificate := false
+		for _, certificate := range profile.DeveloperCertificates {
+			for _, forcedCertificate := range forcedCertificates {
+				if certificate.Serial == forcedCertificate.Serial {
+					profileContainsForcedCertificate = true
+				}
+			}
+		}
+		if !profileContainsForcedCertificate {
+			errors = append(errors, fmt.Sprintf("forced provisioning profile (%s) does not contain the certificate selected by force_code_sign_identity (%s)", forcedProfileName, configs.ForceCodeSignIdentity))
+		}
+	}
+
+	return errors
+}
+
+// checkTarget checks if the target can be signed for the archive with its (resolved) settings and re-signed for the export method.
+func (c codeSignCheck) checkTarget(target string, info xcodeproj.CodeSignInfo) targetCodeSignCheckResult {
+	result := targetCodeSignCheckResult{
+		Target:   target,
+		BundleID: info.BundleIdentifier,
+		TeamID:   info.DevelopmentTeam,
+		Identity: info.CodeSignIdentity,
+		Profile:  firstNonEmpty(info.ProvisioningProfileSpecifier, info.ProvisioningProfile),
+	}
+
+	profileIssue := func(msg string) {
+		if c.allowProvisioningUpdates {
+			result.Warnings = append(result.Warnings, msg)
+		} else {
+			result.Errors = append(result.Errors, msg)
+		}
+	}
+
+	// archive signing
+	identity := info.CodeSignIdentity
+	isAutomatic := info.CodeSignStyle == "Automatic"
+	if identity == "-" {
+		if c.exportMethod == string(exportoptions.MethodAppStore) || c.exportMethod == string(exportoptions.MethodDeveloperID) {
+			result.Errors = append(result.Errors, fmt.Sprintf("signed to run locally (CODE_SIGN_IDENTITY = -), can not be exported for %s", c.exportMethod))
+		}
+	} else if identity != "" || !isAutomatic {
+		if len(c.matchingCertificates(identity, info.DevelopmentTeam)) == 0 {
+			msg := fmt.Sprintf("no installed certificate matches the identity (%s)", identity)
+			if info.DevelopmentTeam != "" {
+				msg = fmt.Sprintf("no installed certificate of team (%s) matches the identity (%s)", info.DevelopmentTeam, identity)
+			}
+			result.Errors = append(result.Errors, msg)
+		}
+	}
+
+	if info.ProvisioningProfile != "" || info.ProvisioningProfileSpecifier != "" {
+		profile, found := c.findProfile(info.ProvisioningProfile, info.ProvisioningProfileSpecifier)
+		if !found {
+			profileIssue(fmt.Sprintf("provisioning profile (%s) is not installed", result.Profile))
+		} else {
+			if info.BundleIdentifier != "" && !glob.Glob(profile.BundleID, info.BundleIdentifier) {
+				result.Errors = append(result.Errors, fmt.Sprintf("provisioning profile (%s) does not cover the bundle ID (%s)", profile.Name, info.BundleIdentifier))
+			}
+			if info.DevelopmentTeam != "" && profile.TeamID != info.DevelopmentTeam {
+				result.Errors = append(result.Errors, fmt.Sprintf("provisioning profile's team (%s) does not match the target's team (%s)", profile.TeamID, info.DevelopmentTeam))
+			}
+			if err := profile.CheckValidity(); err != nil {
+				result.Errors = append(result.Errors, err.Error())
+			}
+			if !profile.HasInstalledCertificate(c.certificates) {
+				result.Errors = append(result.Errors, fmt.Sprintf("none of the provisioning profile's (%s) certificates are installed", profile.Name))
+			}
+		}
+	}
+
+	// export signing
+	if c.exportMethod == "none" || info.BundleIdentifier == "" {
+		return result
+	}
+
+	if len(c.exportProfiles(info.BundleIdentifier, info.DevelopmentTeam)) > 0 {
+		return result
+	}
+
+	switch c.exportMethod {
+	case string(exportoptions.MethodDevelopment), string(exportoptions.MethodDeveloperID):
+		// profile is only required if the app uses entitlements, which need to be provisioned
+		prefixes := developmentCertificatePrefixes
+		if c.exportMethod == string(exportoptions.MethodDeveloperID) {
+			prefixes = developerIDCertificatePrefixes
+		}
+
+		hasCertificate := false
+		for _, certificate := range c.matchingCertificates("", info.DevelopmentTeam) {
+			if hasAnyPrefix(certificate.CommonName, prefixes) {
+				hasCertificate = true
+				break
+			}
+		}
+		if !hasCertificate {
+			result.Errors = append(result.Errors, fmt.Sprintf("no installed %s certificate found for the %s export", strings.Join(prefixes, " or "), c.exportMethod))
+		} else if info.CodeSignEntitlementsPath != "" {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("no %s provisioning profile found, export fails if the entitlements need provisioning", c.exportMethod))
+		}
+	default:
+		profileIssue(fmt.Sprintf("no installed %s provisioning profile covers the bundle ID (%s)", c.exportMethod, info.BundleIdentifier))
+	}
+
+	return result
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// printCodeSignCheckResults prints the per target results as a table.
+func printCodeSignCheckResults(results []targetCodeSignCheckResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tBUNDLE ID\tTEAM\tIDENTITY\tPROFILE\tSTATUS")
+	for _, result := range results {
+		status := "ok"
+		if len(result.Errors) > 0 {
+			status = "FAIL"
+		} else if len(result.Warnings) > 0 {
+			status = "warning"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", result.Target, orDash(result.BundleID), orDash(result.TeamID), orDash(result.Identity), orDash(result.Profile), status)
+	}
+	if err := w.Flush(); err != nil {
+		log.Warnf("Failed to print code sign check results, error: %s", err)
+	}
+
+	for _, result := range results {
+		for _, msg := range result.Errors {
+			log.Errorf("- %s: %s", result.Target, msg)
+		}
+		for _, msg := range result.Warnings {
+			log.Warnf("- %s: %s", result.Target, msg)
+		}
+	}
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// codeSignBuildSettingOverrides returns the build settings the archive command overrides:
+// the force_* inputs and the KEY=VALUE custom options.
+func codeSignBuildSettingOverrides(configs ConfigsModel, customOptions []string) map[string]string {
+	overrides := map[string]string{}
+	for _, option := range customOptions {
+		if strings.HasPrefix(option, "-") {
+			continue
+		}
+		key, value, ok := splitBuildSetting(option)
+		if !ok || strings.Contains(key, "[") {
+			continue
+		}
+		overrides[key] = value
+	}
+
+	forcedSettings := map[string]string{
+		"DEVELOPMENT_TEAM":               configs.ForceTeamID,
+		"CODE_SIGN_IDENTITY":             configs.ForceCodeSignIdentity,
+		"PROVISIONING_PROFILE_SPECIFIER": configs.ForceProvisioningProfileSpecifier,
+		"PROVISIONING_PROFILE":           configs.ForceProvisioningProfile,
+	}
+	for key, value := range forcedSettings {
+		if value != "" {
+			overrides[key] = value
+		}
+	}
+
+	return overrides
+}
+
+// checkCodeSigning checks whether the targets of the scheme can be archived and exported with the installed
+// certificates and profiles, returns error if any of the targets can not be signed.
+func checkCodeSigning(configs ConfigsModel, customOptions []string) error {
+	allowProvisioningUpdates := false
+	for _, option := range customOptions {
+		if option == "-allowProvisioningUpdates" {
+			allowProvisioningUpdates = true
+		}
+	}
+
+	overrides := codeSignBuildSettingOverrides(configs, customOptions)
+	codeSignInfoMap, err := xcodeproj.ResolveCodeSignInfoWithOverrides(configs.ProjectPath, configs.Scheme, configs.Configuration, os.Getenv("USER"), overrides)
+	if err != nil {
+		log.Warnf("Failed to resolve the project's code sign settings, skipping the check, error: %s", err)
+		return nil
+	}
+
+	certificates, err := certificateutil.InstalledCodesigningCertificateInfos()
+	if err != nil {
+		log.Warnf("Failed to get installed certificates, skipping the check, error: %s", err)
+		return nil
+	}
+	certificates = certificateutil.FilterValidCertificateInfos(certificates)
+
+	profiles, err := profileutil.InstalledProvisioningProfileInfos(profileutil.ProfileTypeMacOs)
+	if err != nil {
+		log.Warnf("Failed to get installed provisioning profiles, skipping the check, error: %s", err)
+		return nil
+	}
+
+	return checkCodeSignInfos(configs, codeSignInfoMap, certificates, profiles, allowProvisioningUpdates)
+}
+
+// checkCodeSignInfos checks the targets' resolved code sign settings against the certificates and profiles
+// for every export method, returns error if any of the targets can not be signed.
+func checkCodeSignInfos(configs ConfigsModel, codeSignInfoMap map[string]xcodeproj.CodeSignInfo, certificates []certificateutil.CertificateInfoModel, profiles []profileutil.ProvisioningProfileInfoModel, allowProvisioningUpdates bool) error {
+	// the method of the custom export options is used by the export, if set
+	methods := strings.Split(usedExportMethod(configs), ",")
+	if configs.IsArchiveOnly == "yes" {
+		// the archive is exported by the next steps, only the archive signing is checked
+		methods = []string{"none"}
+	}
+	failedTargets := []string{}
+	for _, method := range methods {
+		check := codeSignCheck{
+			certificates:             certificates,
+			profiles:                 profiles,
+			exportMethod:             method,
+			allowProvisioningUpdates: allowProvisioningUpdates,
+		}
+
+		if errors := check.checkForcedInputs(configs); len(errors) > 0 {
+			return fmt.Errorf("inconsistent force archive codesign settings:\n- %s", strings.Join(errors, "\n- "))
+		}
+
+		targets := []string{}
+		for target := range codeSignInfoMap {
+			targets = append(targets, target)
+		}
+		sort.Strings(targets)
+
+		results := []targetCodeSignCheckResult{}
+		for _, target := range targets {
+			result := check.checkTarget(target, codeSignInfoMap[target])
+			results = append(results, result)
+			if len(result.Errors) > 0 {
+				failedTarget := target
+				if len(methods) > 1 {
+					failedTarget = fmt.Sprintf("%s (%s)", target, method)
+				}
+				failedTargets = append(failedTargets, failedTarget)
+			}
+		}
+
+		if len(methods) > 1 {
+			log.Printf("%s export:", method)
+		}
+		printCodeSignCheckResults(results)
+	}
+
+	if len(failedTargets) > 0 {
+		return fmt.Errorf("the installed certificates and profiles can not sign target(s): %s", strings.Join(failedTargets, ", "))
+	}
+	return nil
+}
+
+// codeSignCheckError returns the check's error in fail mode, otherwise it only warns about the error.
+func codeSignCheckError(mode string, err error) error {
+	if err == nil || mode == codeSignCheckFail {
+		return err
+	}
+	log.Warnf("Code signing check failed, error: %s", err)
+	return nil
+}
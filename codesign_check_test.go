@@ -0,0 +1,442 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitrise-tools/go-xcode/certificateutil"
+	"github.com/bitrise-tools/go-xcode/exportoptions"
+	"github.com/bitrise-tools/go-xcode/profileutil"
+	"github.com/bitrise-tools/go-xcode/xcodeproj"
+)
+
+var (
+	testDevelopmentCertificate = certificateutil.CertificateInfoModel{
+		CommonName:      "Apple Development: John Appleseed (ABCDE12345)",
+		TeamID:          "TEAM111111",
+		Serial:          "1",
+		SHA1Fingerprint: "1A2B3C4D5E6F7A8B9C0D1E2F3A4B5C6D7E8F9A0B",
+	}
+	testDistributionCertificate = certificateutil.CertificateInfoModel{
+		CommonName: "Apple Distribution: Bitrise Ltd. (TEAM111111)",
+		TeamID:     "TEAM111111",
+		Serial:     "2",
+	}
+	testDeveloperIDCertificate = certificateutil.CertificateInfoModel{
+		CommonName: "Developer ID Application: Bitrise Ltd. (TEAM111111)",
+		TeamID:     "TEAM111111",
+		Serial:     "3",
+	}
+	testOtherTeamCertificate = certificateutil.CertificateInfoModel{
+		CommonName: "Apple Development: Jane Doe (FGHIJ67890)",
+		TeamID:     "TEAM222222",
+		Serial:     "4",
+	}
+
+	testCertificates = []certificateutil.CertificateInfoModel{
+		testDevelopmentCertificate,
+		testDistributionCertificate,
+		testDeveloperIDCertificate,
+		testOtherTeamCertificate,
+	}
+
+	testExpiredProfileEndDate = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	testDevelopmentProfile = profileutil.ProvisioningProfileInfoModel{
+		UUID:                  "11111111-1111-1111-1111-111111111111",
+		Name:                  "App Development",
+		TeamID:                "TEAM111111",
+		BundleID:              "io.bitrise.app",
+		ExportType:            exportoptions.MethodDevelopment,
+		DeveloperCertificates: []certificateutil.CertificateInfoModel{testDevelopmentCertificate},
+		ExpirationDate:        time.Now().AddDate(1, 0, 0),
+	}
+	testAppStoreProfile = profileutil.ProvisioningProfileInfoModel{
+		UUID:                  "22222222-2222-2222-2222-222222222222",
+		Name:                  "Bitrise App Store",
+		TeamID:                "TEAM111111",
+		BundleID:              "io.bitrise.*",
+		ExportType:            exportoptions.MethodAppStore,
+		DeveloperCertificates: []certificateutil.CertificateInfoModel{testDistributionCertificate},
+		ExpirationDate:        time.Now().AddDate(1, 0, 0),
+	}
+	testExpiredDeveloperIDProfile = profileutil.ProvisioningProfileInfoModel{
+		UUID:                  "33333333-3333-3333-3333-333333333333",
+		Name:                  "App Developer ID",
+		TeamID:                "TEAM111111",
+		BundleID:              "io.bitrise.app",
+		ExportType:            exportoptions.MethodDeveloperID,
+		DeveloperCertificates: []certificateutil.CertificateInfoModel{testDeveloperIDCertificate},
+		ExpirationDate:        testExpiredProfileEndDate,
+	}
+
+	testProfiles = []profileutil.ProvisioningProfileInfoModel{
+		testDevelopmentProfile,
+		testAppStoreProfile,
+		testExpiredDeveloperIDProfile,
+	}
+)
+
+func TestSplitProfileSpecifier(t *testing.T) {
+	for _, tt := range []struct {
+		specifier string
+		teamID    string
+		name      string
+	}{
+		{specifier: "", teamID: "", name: ""},
+		{specifier: "App Development", teamID: "", name: "App Development"},
+		{specifier: "TEAM111111/App Development", teamID: "TEAM111111", name: "App Development"},
+		{specifier: "TEAM111111/App/Development", teamID: "TEAM111111", name: "App/Development"},
+		// not a team ID
+		{specifier: "Bitrise/App Development", teamID: "", name: "Bitrise/App Development"},
+		{specifier: "team111111/App Development", teamID: "", name: "team111111/App Development"},
+	} {
+		if teamID, name := splitProfileSpecifier(tt.specifier); teamID != tt.teamID || name != tt.name {
+			t.Errorf("splitProfileSpecifier(%q) = (%q, %q), want (%q, %q)", tt.specifier, teamID, name, tt.teamID, tt.name)
+		}
+	}
+}
+
+func TestIdentityMatches(t *testing.T) {
+	for _, tt := range []struct {
+		identity    string
+		certificate certificateutil.CertificateInfoModel
+		want        bool
+	}{
+		{identity: "", certificate: testDevelopmentCertificate, want: true},
+		{identity: "Apple Development: John Appleseed (ABCDE12345)", certificate: testDevelopmentCertificate, want: true},
+		{identity: "Apple Development", certificate: testDevelopmentCertificate, want: true},
+		{identity: "1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b", certificate: testDevelopmentCertificate, want: true},
+		// equivalent identities
+		{identity: "Mac Developer", certificate: testDevelopmentCertificate, want: true},
+		{identity: "Mac Distribution", certificate: testDistributionCertificate, want: true},
+		{identity: "3rd Party Mac Developer Application", certificate: testDistributionCertificate, want: true},
+		{identity: "Apple Distribution", certificate: testDevelopmentCertificate, want: false},
+		{identity: "Mac Developer", certificate: testDeveloperIDCertificate, want: false},
+		// only whole name prefixes select a certificate
+		{identity: "Apple", certificate: testDevelopmentCertificate, want: false},
+		{identity: "Apple Development: John", certificate: testDevelopmentCertificate, want: false},
+	} {
+		if got := identityMatches(tt.identity, tt.certificate); got != tt.want {
+			t.Errorf("identityMatches(%q, %s) = %v, want %v", tt.identity, tt.certificate.CommonName, got, tt.want)
+		}
+	}
+}
+
+func TestCheckForcedInputs(t *testing.T) {
+	for _, tt := range []struct {
+		name                     string
+		configs                  ConfigsModel
+		allowProvisioningUpdates bool
+		want                     []string
+	}{
+		{
+			name:    "no forced inputs",
+			configs: ConfigsModel{},
+			want:    []string{},
+		},
+		{
+			name: "consistent forced inputs",
+			configs: ConfigsModel{
+				ForceTeamID:                       "TEAM111111",
+				ForceCodeSignIdentity:             "Apple Development",
+				ForceProvisioningProfileSpecifier: "TEAM111111/App Development",
+			},
+			want: []string{},
+		},
+		{
+			name: "forced profile by UUID",
+			configs: ConfigsModel{
+				ForceCodeSignIdentity:    "Apple Distribution",
+				ForceProvisioningProfile: testAppStoreProfile.UUID,
+			},
+			want: []string{},
+		},
+		{
+			name: "specifier's team does not match the forced team",
+			configs: ConfigsModel{
+				ForceTeamID:                       "TEAM222222",
+				ForceProvisioningProfileSpecifier: "TEAM111111/App Development",
+			},
+			want: []string{
+				"force_provisioning_profile_specifier's team (TEAM111111) does not match force_team_id (TEAM222222)",
+				"forced provisioning profile's team (TEAM111111) does not match force_team_id (TEAM222222)",
+			},
+		},
+		{
+			name:    "forced identity is not installed",
+			configs: ConfigsModel{ForceCodeSignIdentity: "Developer ID Installer"},
+			want:    []string{"no installed certificate matches force_code_sign_identity (Developer ID Installer)"},
+		},
+		{
+			name:    "forced identity is installed for an other team",
+			configs: ConfigsModel{ForceTeamID: "TEAM222222", ForceCodeSignIdentity: "Apple Distribution"},
+			want:    []string{"force_code_sign_identity (Apple Distribution) does not select any certificate of force_team_id (TEAM222222)"},
+		},
+		{
+			name:    "forced profile is not installed",
+			configs: ConfigsModel{ForceProvisioningProfileSpecifier: "Missing Profile"},
+			want:    []string{"forced provisioning profile (Missing Profile) is not installed"},
+		},
+		{
+			name:                     "forced profile is downloaded with allowProvisioningUpdates",
+			configs:                  ConfigsModel{ForceProvisioningProfileSpecifier: "Missing Profile"},
+			allowProvisioningUpdates: true,
+			want:                     []string{},
+		},
+		{
+			name: "forced profile does not contain the forced identity's certificate",
+			configs: ConfigsModel{
+				ForceCodeSignIdentity:             "Apple Distribution",
+				ForceProvisioningProfileSpecifier: "App Development",
+			},
+			want: []string{"forced provisioning profile (App Development) does not contain the certificate selected by force_code_sign_identity (Apple Distribution)"},
+		},
+	} {
+		check := codeSignCheck{
+			certificates:             testCertificates,
+			profiles:                 testProfiles,
+			exportMethod:             string(exportoptions.MethodDevelopment),
+			allowProvisioningUpdates: tt.allowProvisioningUpdates,
+		}
+		if got := check.checkForcedInputs(tt.configs); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckTarget(t *testing.T) {
+	automatic := xcodeproj.CodeSignInfo{
+		BundleIdentifier: "io.bitrise.app",
+		CodeSignStyle:    "Automatic",
+		DevelopmentTeam:  "TEAM111111",
+	}
+	withInfo := func(modify func(info *xcodeproj.CodeSignInfo)) xcodeproj.CodeSignInfo {
+		info := automatic
+		modify(&info)
+		return info
+	}
+
+	for _, tt := range []struct {
+		name                     string
+		exportMethod             string
+		certificates             []certificateutil.CertificateInfoModel
+		allowProvisioningUpdates bool
+		info                     xcodeproj.CodeSignInfo
+		wantErrors               []string
+		wantWarnings             []string
+	}{
+		{
+			name:         "development export with a development profile",
+			exportMethod: "development",
+			info:         automatic,
+		},
+		{
+			name:         "development export with a development certificate only",
+			exportMethod: "development",
+			info:         withInfo(func(info *xcodeproj.CodeSignInfo) { info.BundleIdentifier = "io.bitrise.helper" }),
+		},
+		{
+			name:         "development export without profile, with entitlements",
+			exportMethod: "development",
+			info: withInfo(func(info *xcodeproj.CodeSignInfo) {
+				info.BundleIdentifier = "io.bitrise.helper"
+				info.CodeSignEntitlementsPath = "Helper/Helper.entitlements"
+			}),
+			wantWarnings: []string{"no development provisioning profile found, export fails if the entitlements need provisioning"},
+		},
+		{
+			name:         "app-store export with a wildcard profile",
+			exportMethod: "app-store",
+			info:         automatic,
+		},
+		{
+			name:         "app-store export without profile",
+			exportMethod: "app-store",
+			info:         withInfo(func(info *xcodeproj.CodeSignInfo) { info.BundleIdentifier = "com.other.app" }),
+			wantErrors:   []string{"no installed app-store provisioning profile covers the bundle ID (com.other.app)"},
+		},
+		{
+			name:                     "app-store export without profile, with allowProvisioningUpdates",
+			exportMethod:             "app-store",
+			allowProvisioningUpdates: true,
+			info:                     withInfo(func(info *xcodeproj.CodeSignInfo) { info.BundleIdentifier = "com.other.app" }),
+			wantWarnings:             []string{"no installed app-store provisioning profile covers the bundle ID (com.other.app)"},
+		},
+		{
+			name:         "developer-id export with a Developer ID certificate, the expired profile is skipped",
+			exportMethod: "developer-id",
+			info:         automatic,
+		},
+		{
+			name:         "developer-id export without a Developer ID certificate",
+			exportMethod: "developer-id",
+			certificates: []certificateutil.CertificateInfoModel{testDevelopmentCertificate, testDistributionCertificate},
+			info:         automatic,
+			wantErrors:   []string{"no installed Developer ID Application certificate found for the developer-id export"},
+		},
+		{
+			name:         "developer-id export of a target signed to run locally",
+			exportMethod: "developer-id",
+			info:         withInfo(func(info *xcodeproj.CodeSignInfo) { info.CodeSignIdentity = "-" }),
+			wantErrors:   []string{"signed to run locally (CODE_SIGN_IDENTITY = -), can not be exported for developer-id"},
+		},
+		{
+			name:         "archive only: only the archive signing is checked",
+			exportMethod: "none",
+			info: withInfo(func(info *xcodeproj.CodeSignInfo) {
+				info.BundleIdentifier = "com.other.app"
+				info.CodeSignIdentity = "-"
+			}),
+		},
+		{
+			name:         "manual signing with an identity of an other team",
+			exportMethod: "none",
+			info: withInfo(func(info *xcodeproj.CodeSignInfo) {
+				info.CodeSignStyle = "Manual"
+				info.CodeSignIdentity = "Apple Distribution"
+				info.DevelopmentTeam = "TEAM222222"
+			}),
+			wantErrors: []string{"no installed certificate of team (TEAM222222) matches the identity (Apple Distribution)"},
+		},
+		{
+			name:         "manual signing with a profile, which is not installed",
+			exportMethod: "none",
+			info: withInfo(func(info *xcodeproj.CodeSignInfo) {
+				info.CodeSignStyle = "Manual"
+				info.CodeSignIdentity = "Apple Development"
+				info.ProvisioningProfileSpecifier = "Missing Profile"
+			}),
+			wantErrors: []string{"provisioning profile (Missing Profile) is not installed"},
+		},
+		{
+			name:                     "manual signing with a profile, which is downloaded with allowProvisioningUpdates",
+			exportMethod:             "none",
+			allowProvisioningUpdates: true,
+			info: withInfo(func(info *xcodeproj.CodeSignInfo) {
+				info.CodeSignStyle = "Manual"
+				info.CodeSignIdentity = "Apple Development"
+				info.ProvisioningProfileSpecifier = "Missing Profile"
+			}),
+			wantWarnings: []string{"provisioning profile (Missing Profile) is not installed"},
+		},
+		{
+			name:         "manual signing with a profile of an other bundle ID",
+			exportMethod: "none",
+			info: withInfo(func(info *xcodeproj.CodeSignInfo) {
+				info.CodeSignStyle = "Manual"
+				info.CodeSignIdentity = "Apple Development"
+				info.BundleIdentifier = "io.bitrise.helper"
+				info.ProvisioningProfile = testDevelopmentProfile.UUID
+			}),
+			wantErrors: []string{"provisioning profile (App Development) does not cover the bundle ID (io.bitrise.helper)"},
+		},
+		{
+			name:         "manual signing with an expired profile",
+			exportMethod: "none",
+			info: withInfo(func(info *xcodeproj.CodeSignInfo) {
+				info.CodeSignStyle = "Manual"
+				info.CodeSignIdentity = "Developer ID Application"
+				info.ProvisioningProfileSpecifier = "TEAM111111/App Developer ID"
+			}),
+			wantErrors: []string{"Provisioning Profile is not valid anymore - validity ended at: " + testExpiredProfileEndDate.String()},
+		},
+	} {
+		certificates := tt.certificates
+		if certificates == nil {
+			certificates = testCertificates
+		}
+		check := codeSignCheck{
+			certificates:             certificates,
+			profiles:                 testProfiles,
+			exportMethod:             tt.exportMethod,
+			allowProvisioningUpdates: tt.allowProvisioningUpdates,
+		}
+
+		result := check.checkTarget("App", tt.info)
+		if !reflect.DeepEqual(result.Errors, tt.wantErrors) {
+			t.Errorf("%s: got errors %q, want %q", tt.name, result.Errors, tt.wantErrors)
+		}
+		if !reflect.DeepEqual(result.Warnings, tt.wantWarnings) {
+			t.Errorf("%s: got warnings %q, want %q", tt.name, result.Warnings, tt.wantWarnings)
+		}
+	}
+}
+
+func TestCheckCodeSignInfos(t *testing.T) {
+	codeSignInfoMap := map[string]xcodeproj.CodeSignInfo{
+		"App":    {BundleIdentifier: "io.bitrise.app", CodeSignStyle: "Automatic", DevelopmentTeam: "TEAM111111"},
+		"Helper": {BundleIdentifier: "com.other.helper", CodeSignStyle: "Automatic", DevelopmentTeam: "TEAM111111"},
+	}
+
+	for _, tt := range []struct {
+		name                     string
+		configs                  ConfigsModel
+		allowProvisioningUpdates bool
+		wantErr                  string
+	}{
+		{
+			name:    "every target can be signed for the export method",
+			configs: ConfigsModel{ExportMethod: "development"},
+		},
+		{
+			name:    "failed targets of multiple export methods",
+			configs: ConfigsModel{ExportMethod: "development\napp-store"},
+			wantErr: "the installed certificates and profiles can not sign target(s): Helper (app-store)",
+		},
+		{
+			name:    "failed target of a single export method",
+			configs: ConfigsModel{ExportMethod: "app-store"},
+			wantErr: "the installed certificates and profiles can not sign target(s): Helper",
+		},
+		{
+			name:                     "missing profiles are downloaded with allowProvisioningUpdates",
+			configs:                  ConfigsModel{ExportMethod: "app-store"},
+			allowProvisioningUpdates: true,
+		},
+		{
+			name:    "the custom export options' method is checked",
+			configs: ConfigsModel{ExportMethod: "development", CustomExportOptionsPlistContent: testAppStoreExportOptionsContent},
+			wantErr: "the installed certificates and profiles can not sign target(s): Helper",
+		},
+		{
+			name:    "archive only: the export is not checked",
+			configs: ConfigsModel{ExportMethod: "app-store", IsArchiveOnly: "yes"},
+		},
+		{
+			name:    "inconsistent forced inputs",
+			configs: ConfigsModel{ExportMethod: "development", ForceCodeSignIdentity: "Developer ID Installer"},
+			wantErr: "inconsistent force archive codesign settings:\n- no installed certificate matches force_code_sign_identity (Developer ID Installer)",
+		},
+	} {
+		err := checkCodeSignInfos(tt.configs, codeSignInfoMap, testCertificates, testProfiles, tt.allowProvisioningUpdates)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.wantErr {
+			t.Errorf("%s: got error %v, want %s", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCodeSignCheckError(t *testing.T) {
+	checkErr := errors.New("the installed certificates and profiles can not sign target(s): App")
+
+	if err := codeSignCheckError(codeSignCheckFail, checkErr); err != checkErr {
+		t.Errorf("fail mode: got %v, want %s", err, checkErr)
+	}
+	if err := codeSignCheckError(codeSignCheckWarn, checkErr); err != nil {
+		t.Errorf("warn mode: unexpected error: %s", err)
+	}
+	if err := codeSignCheckError(codeSignCheckFail, nil); err != nil {
+		t.Errorf("fail mode without check error: unexpected error: %s", err)
+	}
+}
+
+var testAppStoreExportOptionsContent = strings.Replace(testDeveloperIDExportOptionsContent, "developer-id", "app-store", 1)
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+const (
+	dependencyCheckFail = "fail"
+	dependencyCheckWarn = "warn"
+	dependencyCheckNone = "none"
+)
+
+// podLock is the parsed content of the Podfile.lock and the Pods/Manifest.lock files.
+type podLock struct {
+	Pods             map[string]string
+	PodfileChecksum  string
+	CocoaPodsVersion string
+}
+
+// podLockPodRegexp matches the pods of the PODS section, like: `  - Alamofire (5.4.0)`, `  - Firebase/Core (7.0.0):`
+// or `  - "GoogleUtilities/Environment (7.1.0)":`
+var podLockPodRegexp = regexp.MustCompile(`^  - "?([^" ]+) \(([^)]+)\)"?:?$`)
+
+// parsePodLock parses the PODS section, the PODFILE CHECKSUM and the COCOAPODS version of a Podfile.lock.
+func parsePodLock(content string) (podLock, error) {
+	lock := podLock{Pods: map[string]string{}}
+
+	section := ""
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \r")
+		if line == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(line, " ") {
+			split := strings.SplitN(line, ":", 2)
+			section = split[0]
+			value := ""
+			if len(split) == 2 {
+				value = strings.TrimSpace(split[1])
+			}
+
+			switch section {
+			case "PODFILE CHECKSUM":
+				lock.PodfileChecksum = value
+			case "COCOAPODS":
+				lock.CocoaPodsVersion = value
+			}
+			continue
+		}
+
+		if section != "PODS" {
+			continue
+		}
+		if match := podLockPodRegexp.FindStringSubmatch(line); len(match) == 3 {
+			lock.Pods[match[1]] = match[2]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return podLock{}, err
+	}
+
+	if len(lock.Pods) == 0 && lock.PodfileChecksum == "" {
+		return podLock{}, fmt.Errorf("no PODS section and PODFILE CHECKSUM found")
+	}
+	return lock, nil
+}
+
+// comparePodLocks returns the differences between the Podfile.lock and the installed Pods/Manifest.lock.
+func comparePodLocks(podfileLock, manifestLock podLock) []string {
+	drifts := []string{}
+
+	if podfileLock.PodfileChecksum != manifestLock.PodfileChecksum {
+		drifts = append(drifts, fmt.Sprintf("PODFILE CHECKSUM differs: %s (Podfile.lock) vs %s (Manifest.lock)", podfileLock.PodfileChecksum, manifestLock.PodfileChecksum))
+	}
+
+	names := []string{}
+	for name := range podfileLock.Pods {
+		names = append(names, name)
+	}
+	for name := range manifestLock.Pods {
+		if _, ok := podfileLock.Pods[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		locked, isLocked := podfileLock.Pods[name]
+		installed, isInstalled := manifestLock.Pods[name]
+		switch {
+		case !isInstalled:
+			drifts = append(drifts, fmt.Sprintf("%s (%s) is not installed", name, locked))
+		case !isLocked:
+			drifts = append(drifts, fmt.Sprintf("%s (%s) is installed, but not in the Podfile.lock", name, installed))
+		case locked != installed:
+			drifts = append(drifts, fmt.Sprintf("%s is locked at %s, but %s is installed", name, locked, installed))
+		}
+	}
+
+	return drifts
+}
+
+// checkCocoaPods compares the Podfile.lock with the Pods/Manifest.lock in the given directory.
+func checkCocoaPods(dir string) ([]string, error) {
+	podfilePth := filepath.Join(dir, "Podfile")
+	podfileLockPth := filepath.Join(dir, "Podfile.lock")
+	manifestLockPth := filepath.Join(dir, "Pods", "Manifest.lock")
+
+	if exist, err := pathutil.IsPathExists(podfilePth); err != nil {
+		return nil, err
+	} else if !exist {
+		return nil, nil
+	}
+	log.Printf("Podfile found: %s", podfilePth)
+
+	if exist, err := pathutil.IsPathExists(podfileLockPth); err != nil {
+		return nil, err
+	} else if !exist {
+		return []string{"Podfile.lock not found, run pod install and commit the Podfile.lock"}, nil
+	}
+
+	if exist, err := pathutil.IsPathExists(manifestLockPth); err != nil {
+		return nil, err
+	} else if !exist {
+		return []string{"Pods/Manifest.lock not found, the pods are not installed: run pod install before the archive"}, nil
+	}
+
+	podfileLockContent, err := fileutil.ReadStringFromFile(podfileLockPth)
+	if err != nil {
+		return nil, err
+	}
+	podfileLock, err := parsePodLock(podfileLockContent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Podfile.lock, error: %s", err)
+	}
+
+	manifestLockContent, err := fileutil.ReadStringFromFile(manifestLockPth)
+	if err != nil {
+		return nil, err
+	}
+	manifestLock, err := parsePodLock(manifestLockContent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Pods/Manifest.lock, error: %s", err)
+	}
+
+	log.Printf("%d pod(s) locked, installed with CocoaPods %s", len(podfileLock.Pods), manifestLock.CocoaPodsVersion)
+
+	drifts := comparePodLocks(podfileLock, manifestLock)
+	if len(drifts) > 0 {
+		drifts = append(drifts, "the Pods directory is out of sync with the Podfile.lock: run pod install before the archive")
+	}
+	return drifts, nil
+}
+
+// cartfileDependency is a line of the Cartfile.resolved.
+type cartfileDependency struct {
+	Origin  string
+	Source  string
+	Version string
+}
+
+// Name returns the name of the built framework's version file: the last path component of the source, without the .git extension.
+func (d cartfileDependency) Name() string {
+	name := strings.TrimSuffix(d.Source, "/")
+	if i := strings.LastIndexAny(name, "/:"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSuffix(name, ".git")
+	return strings.TrimSuffix(name, ".json")
+}
+
+// github "Alamofire/Alamofire" "5.4.0"
+var cartfileResolvedLineRegexp = regexp.MustCompile(`^(github|git|binary) "([^"]+)" "([^"]+)"$`)
+
+// parseCartfileResolved ...
+func parseCartfileResolved(content string) ([]cartfileDependency, error) {
+	dependencies := []cartfileDependency{}
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		match := cartfileResolvedLineRegexp.FindStringSubmatch(line)
+		if len(match) != 4 {
+			return nil, fmt.Errorf("invalid Cartfile.resolved line: %s", line)
+		}
+		dependencies = append(dependencies, cartfileDependency{
+			Origin:  match[1],
+			Source:  match[2],
+			Version: match[3],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return dependencies, nil
+}
+
+// carthageVersionFile is the content of the Carthage/Build/.<Name>.version file, written by Carthage after building a dependency.
+type carthageVersionFile struct {
+	Commitish string                   `json:"commitish"`
+	Mac       []map[string]interface{} `json:"Mac"`
+}
+
+func parseCarthageVersionFile(content string) (carthageVersionFile, error) {
+	var versionFile carthageVersionFile
+	if err := json.Unmarshal([]byte(content), &versionFile); err != nil {
+		return carthageVersionFile{}, err
+	}
+	return versionFile, nil
+}
+
+// compareCarthageBuild returns the dependencies, which are not built for macOS at the resolved version.
+// The versionFiles maps the dependency names to the content of their version files.
+func compareCarthageBuild(dependencies []cartfileDependency, versionFiles map[string]string) []string {
+	drifts := []string{}
+	for _, dependency := range dependencies {
+		content, ok := versionFiles[dependency.Name()]
+		if !ok {
+			drifts = append(drifts, fmt.Sprintf("%s (%s) is not built", dependency.Name(), dependency.Version))
+			continue
+		}
+
+		versionFile, err := parseCarthageVersionFile(content)
+		if err != nil {
+			drifts = append(drifts, fmt.Sprintf("%s: invalid version file, error: %s", dependency.Name(), err))
+			continue
+		}
+
+		if versionFile.Commitish != dependency.Version {
+			drifts = append(drifts, fmt.Sprintf("%s is resolved at %s, but %s is built", dependency.Name(), dependency.Version, versionFile.Commitish))
+		} else if len(versionFile.Mac) == 0 {
+			drifts = append(drifts, fmt.Sprintf("%s (%s) is not built for macOS", dependency.Name(), dependency.Version))
+		}
+	}
+	return drifts
+}
+
+// checkCarthage compares the Cartfile.resolved with the built frameworks' version files in the given directory.
+func checkCarthage(dir string) ([]string, error) {
+	cartfileResolvedPth := filepath.Join(dir, "Cartfile.resolved")
+	buildDir := filepath.Join(dir, "Carthage", "Build")
+
+	if exist, err := pathutil.IsPathExists(cartfileResolvedPth); err != nil {
+		return nil, err
+	} else if !exist {
+		if exist, err := pathutil.IsPathExists(filepath.Join(dir, "Cartfile")); err != nil {
+			return nil, err
+		} else if exist {
+			return []string{"Cartfile.resolved not found, run carthage bootstrap and commit the Cartfile.resolved"}, nil
+		}
+		return nil, nil
+	}
+	log.Printf("Cartfile.resolved found: %s", cartfileResolvedPth)
+
+	content, err := fileutil.ReadStringFromFile(cartfileResolvedPth)
+	if err != nil {
+		return nil, err
+	}
+	dependencies, err := parseCartfileResolved(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Cartfile.resolved, error: %s", err)
+	}
+	log.Printf("%d Carthage dependencies resolved", len(dependencies))
+
+	if len(dependencies) == 0 {
+		return nil, nil
+	}
+
+	if exist, err := pathutil.IsPathExists(buildDir); err != nil {
+		return nil, err
+	} else if !exist {
+		return []string{"Carthage/Build not found, the dependencies are not built: run carthage bootstrap --platform macOS before the archive"}, nil
+	}
+
+	versionFiles := map[string]string{}
+	for _, dependency := range dependencies {
+		versionFilePth := filepath.Join(buildDir, "."+dependency.Name()+".version")
+		if exist, err := pathutil.IsPathExists(versionFilePth); err != nil {
+			return nil, err
+		} else if !exist {
+			continue
+		}
+
+		versionFileContent, err := fileutil.ReadStringFromFile(versionFilePth)
+		if err != nil {
+			return nil, err
+		}
+		versionFiles[dependency.Name()] = versionFileContent
+	}
+
+	drifts := compareCarthageBuild(dependencies, versionFiles)
+	if len(drifts) > 0 {
+		drifts = append(drifts, "the Carthage/Build directory is out of sync with the Cartfile.resolved: run carthage bootstrap --platform macOS before the archive")
+	}
+	return drifts, nil
+}
+
+// checkDependencies runs the CocoaPods and Carthage checks in the project's directory,
+// returns the detected drifts.
+func checkDependencies(projectPth string) ([]string, error) {
+	dir := filepath.Dir(projectPth)
+
+	podDrifts, err := checkCocoaPods(dir)
+	if err != nil {
+		return nil, fmt.Errorf("CocoaPods check failed, error: %s", err)
+	}
+
+	carthageDrifts, err := checkCarthage(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Carthage check failed, error: %s", err)
+	}
+
+	drifts := []string{}
+	for _, drift := range podDrifts {
+		drifts = append(drifts, "CocoaPods: "+drift)
+	}
+	for _, drift := range carthageDrifts {
+		drifts = append(drifts, "Carthage: "+drift)
+	}
+	return drifts, nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePodLock(t *testing.T) {
+	t.Log("Podfile.lock")
+	{
+		lock, err := parsePodLock(testPodfileLockContent)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		want := podLock{
+			Pods: map[string]string{
+				"Alamofire":                   "5.4.0",
+				"Firebase/Core":               "7.0.0",
+				"FirebaseCore":                "7.0.0",
+				"GoogleUtilities/Environment": "7.1.0",
+				"GoogleUtilities/Logger":      "7.1.0",
+				"PromisesObjC":                "1.2.11",
+				"SwiftLint":                   "0.43.1",
+			},
+			PodfileChecksum:  "9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b",
+			CocoaPodsVersion: "1.10.1",
+		}
+		if !reflect.DeepEqual(lock, want) {
+			t.Errorf("got %+v, want %+v", lock, want)
+		}
+	}
+
+	t.Log("Windows line endings")
+	{
+		lock, err := parsePodLock("PODS:\r\n  - Alamofire (5.4.0)\r\n\r\nPODFILE CHECKSUM: abc\r\n")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if lock.Pods["Alamofire"] != "5.4.0" || lock.PodfileChecksum != "abc" {
+			t.Errorf("unexpected lock: %+v", lock)
+		}
+	}
+
+	t.Log("without pods")
+	{
+		lock, err := parsePodLock("PODFILE CHECKSUM: abc\n\nCOCOAPODS: 1.10.1\n")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(lock.Pods) != 0 || lock.PodfileChecksum != "abc" || lock.CocoaPodsVersion != "1.10.1" {
+			t.Errorf("unexpected lock: %+v", lock)
+		}
+	}
+
+	t.Log("invalid content")
+	{
+		for _, content := range []string{"", "not a lock file", "DEPENDENCIES:\n  - Alamofire\n"} {
+			if _, err := parsePodLock(content); err == nil {
+				t.Errorf("expected error for content: %q", content)
+			}
+		}
+	}
+}
+
+func TestComparePodLocks(t *testing.T) {
+	podfileLock := podLock{
+		Pods:            map[string]string{"Alamofire": "5.4.0", "SwiftLint": "0.43.1"},
+		PodfileChecksum: "abc",
+	}
+
+	t.Log("in sync")
+	{
+		manifestLock := podLock{
+			Pods:             map[string]string{"Alamofire": "5.4.0", "SwiftLint": "0.43.1"},
+			PodfileChecksum:  "abc",
+			CocoaPodsVersion: "1.10.1",
+		}
+		if drifts := comparePodLocks(podfileLock, manifestLock); len(drifts) != 0 {
+			t.Errorf("unexpected drifts: %v", drifts)
+		}
+	}
+
+	t.Log("out of sync")
+	{
+		manifestLock := podLock{
+			Pods:            map[string]string{"Alamofire": "5.2.0", "Kingfisher": "6.0.0"},
+			PodfileChecksum: "def",
+		}
+		want := []string{
+			"PODFILE CHECKSUM differs: abc (Podfile.lock) vs def (Manifest.lock)",
+			"Alamofire is locked at 5.4.0, but 5.2.0 is installed",
+			"Kingfisher (6.0.0) is installed, but not in the Podfile.lock",
+			"SwiftLint (0.43.1) is not installed",
+		}
+		if drifts := comparePodLocks(podfileLock, manifestLock); !reflect.DeepEqual(drifts, want) {
+			t.Errorf("got %q, want %q", drifts, want)
+		}
+	}
+}
+
+func TestParseCartfileResolved(t *testing.T) {
+	t.Log("Cartfile.resolved")
+	{
+		dependencies, err := parseCartfileResolved(testCartfileResolvedContent)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		want := []cartfileDependency{
+			{Origin: "binary", Source: "https://dl.google.com/dl/firebase/ios/carthage/FirebaseAnalyticsBinary.json", Version: "7.0.0"},
+			{Origin: "git", Source: "https://gitlab.com/team/Networking.git", Version: "1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b"},
+			{Origin: "git", Source: "git@bitbucket.org:team/Storage.git", Version: "v2.1.0"},
+			{Origin: "github", Source: "Alamofire/Alamofire", Version: "5.4.0"},
+			{Origin: "github", Source: "ReactiveX/RxSwift", Version: "6.1.0"},
+		}
+		if !reflect.DeepEqual(dependencies, want) {
+			t.Errorf("got %+v, want %+v", dependencies, want)
+		}
+
+		names := []string{}
+		for _, dependency := range dependencies {
+			names = append(names, dependency.Name())
+		}
+		wantNames := []string{"FirebaseAnalyticsBinary", "Networking", "Storage", "Alamofire", "RxSwift"}
+		if !reflect.DeepEqual(names, wantNames) {
+			t.Errorf("got names %q, want %q", names, wantNames)
+		}
+	}
+
+	t.Log("empty")
+	{
+		dependencies, err := parseCartfileResolved("\n# no dependencies\n")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(dependencies) != 0 {
+			t.Errorf("unexpected dependencies: %+v", dependencies)
+		}
+	}
+
+	t.Log("invalid lines")
+	{
+		for _, content := range []string{
+			`github "Alamofire/Alamofire"`,
+			`svn "Alamofire/Alamofire" "5.4.0"`,
+			`github Alamofire/Alamofire 5.4.0`,
+			`github "Alamofire/Alamofire" ~> 5.4`,
+		} {
+			if _, err := parseCartfileResolved(content); err == nil {
+				t.Errorf("expected error for content: %q", content)
+			}
+		}
+	}
+}
+
+func TestCompareCarthageBuild(t *testing.T) {
+	dependencies := []cartfileDependency{
+		{Origin: "github", Source: "Alamofire/Alamofire", Version: "5.4.0"},
+		{Origin: "github", Source: "ReactiveX/RxSwift", Version: "6.1.0"},
+		{Origin: "github", Source: "onevcat/Kingfisher", Version: "6.0.0"},
+		{Origin: "git", Source: "https://gitlab.com/team/Networking.git", Version: "1.0.0"},
+		{Origin: "github", Source: "realm/SwiftLint", Version: "0.43.1"},
+	}
+
+	t.Log("in sync")
+	{
+		versionFiles := map[string]string{}
+		for _, dependency := range dependencies {
+			versionFiles[dependency.Name()] = `{"commitish" : "` + dependency.Version + `", "Mac" : [{"name" : "` + dependency.Name() + `", "hash" : "abc"}]}`
+		}
+		if drifts := compareCarthageBuild(dependencies, versionFiles); len(drifts) != 0 {
+			t.Errorf("unexpected drifts: %v", drifts)
+		}
+	}
+
+	t.Log("out of sync")
+	{
+		versionFiles := map[string]string{
+			"Alamofire":  testCarthageVersionFileContent,
+			"RxSwift":    `{"commitish" : "6.0.0", "Mac" : [{"name" : "RxSwift", "hash" : "abc"}]}`,
+			"Kingfisher": `{"commitish" : "6.0.0", "iOS" : [{"name" : "Kingfisher", "hash" : "abc"}]}`,
+			"Networking": `{"commitish" : `,
+		}
+		want := []string{
+			"RxSwift is resolved at 6.1.0, but 6.0.0 is built",
+			"Kingfisher (6.0.0) is not built for macOS",
+			"Networking: invalid version file, error: unexpected end of JSON input",
+			"SwiftLint (0.43.1) is not built",
+		}
+		if drifts := compareCarthageBuild(dependencies, versionFiles); !reflect.DeepEqual(drifts, want) {
+			t.Errorf("got %q, want %q", drifts, want)
+		}
+	}
+}
+
+const testPodfileLockContent = `PODS:
+  - Alamofire (5.4.0)
+  - Firebase/Core (7.0.0):
+    - FirebaseCore (= 7.0.0)
+  - FirebaseCore (7.0.0):
+    - "GoogleUtilities/Environment (~> 7.0)"
+    - "GoogleUtilities/Logger (~> 7.0)"
+  - "GoogleUtilities/Environment (7.1.0)":
+    - PromisesObjC (~> 1.2)
+  - "GoogleUtilities/Logger (7.1.0)":
+    - GoogleUtilities/Environment
+  - PromisesObjC (1.2.11)
+  - SwiftLint (0.43.1)
+
+DEPENDENCIES:
+  - Alamofire (~> 5.4)
+  - Firebase/Core
+  - SwiftLint
+
+SPEC REPOS:
+  trunk:
+    - Alamofire
+    - Firebase
+    - FirebaseCore
+    - GoogleUtilities
+    - PromisesObjC
+    - SwiftLint
+
+SPEC CHECKSUMS:
+  Alamofire: 3ec537f71edc9804815215393ae2b1a8ea33a844
+  Firebase: 50be68416f50eb4eb2ecb0e78acab9a051ef95df
+  FirebaseCore: cf3122185fce1cf71cedbbc498ea84d2b3e7cb69
+  GoogleUtilities: e1d9ed4e544fc32a93e00e721400cbc3f377200d
+  PromisesObjC: 8c196f5a328c2cba3e74624585467a557dcb482f
+  SwiftLint: 99f82d07b837b942dd563c668de129a03fc3fb52
+
+PODFILE CHECKSUM: 9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b
+
+COCOAPODS: 1.10.1
+`
+
+const testCartfileResolvedContent = `binary "https://dl.google.com/dl/firebase/ios/carthage/FirebaseAnalyticsBinary.json" "7.0.0"
+git "https://gitlab.com/team/Networking.git" "1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b"
+git "git@bitbucket.org:team/Storage.git" "v2.1.0"
+github "Alamofire/Alamofire" "5.4.0"
+github "ReactiveX/RxSwift" "6.1.0"
+`
+
+const testCarthageVersionFileContent = `{
+  "Mac" : [
+    {
+      "name" : "Alamofire",
+      "hash" : "e7b8e1ff6a8eb1b8e9e6bfb1a2d1d0b5e6f3c1d9a8e7f6b5c4d3e2f1a0b9c8d7",
+      "linking" : "dynamic",
+      "swiftToolchainVersion" : "5.3.2 (swiftlang-1200.0.45 clang-1200.0.32.28)"
+    }
+  ],
+  "watchOS" : [
+
+  ],
+  "tvOS" : [
+
+  ],
+  "commitish" : "5.4.0",
+  "iOS" : [
+
+  ]
+}`
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// derivedDataCacheExcludes are the DerivedData subdirectories not worth caching:
+// the module cache is machine specific, the index and the build logs are not used by the next builds,
+// the Swift packages are cached separately, keyed by the Package.resolved file.
+var derivedDataCacheExcludes = []string{
+	"ModuleCache",
+	"ModuleCache.noindex",
+	"Index",
+	"Index.noindex",
+	"Logs",
+	"SourcePackages",
+}
+
+// cacheDerivedData registers the DerivedData directory in the build cache, without the excluded subdirectories.
+func cacheDerivedData(derivedDataPath string, stepCache *buildCache) error {
+	excludes := []string{}
+	for _, exclude := range derivedDataCacheExcludes {
+		excludes = append(excludes, filepath.Join(derivedDataPath, exclude))
+	}
+
+	if err := stepCache.register([]string{derivedDataPath}, excludes); err != nil {
+		return fmt.Errorf("failed to register DerivedData in the cache, error: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-io/go-utils/ziputil"
+)
+
+const (
+	xcarchiveExt    = ".xcarchive"
+	xcarchiveZipExt = ".xcarchive.zip"
+)
+
+// existingArchiveName returns the name of the archive without the .xcarchive or .xcarchive.zip extension.
+func existingArchiveName(archivePth string) string {
+	name := filepath.Base(archivePth)
+	for _, ext := range []string{xcarchiveZipExt, ".zip", xcarchiveExt} {
+		if strings.HasSuffix(name, ext) {
+			return strings.TrimSuffix(name, ext)
+		}
+	}
+	return name
+}
+
+// openExistingArchive returns the path of the xcarchive to export: an archive directory is used in place,
+// a zipped archive is unpacked into the given directory.
+func openExistingArchive(archivePth, tmpDir string) (string, error) {
+	if exist, err := pathutil.IsPathExists(archivePth); err != nil {
+		return "", err
+	} else if !exist {
+		return "", fmt.Errorf("archive (%s) does not exist", archivePth)
+	}
+
+	if isDir, err := pathutil.IsDirExists(archivePth); err != nil {
+		return "", err
+	} else if isDir {
+		if filepath.Ext(archivePth) != xcarchiveExt {
+			return "", fmt.Errorf("not an xcarchive, the extension should be %s", xcarchiveExt)
+		}
+		return archivePth, nil
+	}
+
+	if filepath.Ext(archivePth) != ".zip" {
+		return "", fmt.Errorf("not an xcarchive, the extension should be %s or %s", xcarchiveExt, xcarchiveZipExt)
+	}
+
+	unzipDir := filepath.Join(tmpDir, "existing-archive")
+	log.Printf("Unzipping %s into %s", archivePth, unzipDir)
+	if err := ziputil.UnZip(archivePth, unzipDir); err != nil {
+		return "", err
+	}
+
+	matches, err := filepath.Glob(filepath.Join(unzipDir, "*"+xcarchiveExt))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no %s found in the zip", xcarchiveExt)
+	} else if len(matches) > 1 {
+		return "", fmt.Errorf("multiple %s found in the zip: %s", xcarchiveExt, strings.Join(matches, ", "))
+	}
+	return matches[0], nil
+}
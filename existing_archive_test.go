@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/ziputil"
+	"github.com/bitrise-tools/go-xcode/xcarchive"
+)
+
+func TestExistingArchiveName(t *testing.T) {
+	for _, tt := range []struct {
+		pth  string
+		want string
+	}{
+		{pth: "/tmp/App.xcarchive", want: "App"},
+		{pth: "/tmp/App.xcarchive/", want: "App"},
+		{pth: "/tmp/App.xcarchive.zip", want: "App"},
+		{pth: "/tmp/App 2021-10-18 12.00.00.xcarchive", want: "App 2021-10-18 12.00.00"},
+		{pth: "/tmp/App.zip", want: "App"},
+		{pth: "App", want: "App"},
+	} {
+		if got := existingArchiveName(tt.pth); got != tt.want {
+			t.Errorf("existingArchiveName(%q) = %q, want %q", tt.pth, got, tt.want)
+		}
+	}
+}
+
+func TestOpenExistingArchive(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "existing-archive")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Logf("failed to remove tmp dir: %s", err)
+		}
+	}()
+
+	archivePth := createTestArchive(t, filepath.Join(tmpDir, "archives"), "App", "42", "2.1.0")
+
+	// openTmpDir returns a new directory to unzip the archive into.
+	openTmpDir := func(name string) string {
+		dir := filepath.Join(tmpDir, "open", name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("failed to create dir: %s", err)
+		}
+		return dir
+	}
+
+	t.Log("xcarchive directory is used in place")
+	{
+		got, err := openExistingArchive(archivePth, openTmpDir("dir"))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if got != archivePth {
+			t.Errorf("got %s, want %s", got, archivePth)
+		}
+	}
+
+	t.Log("xcarchive zip is unzipped")
+	{
+		zipPth := filepath.Join(tmpDir, "App.xcarchive.zip")
+		if err := ziputil.ZipDir(archivePth, zipPth, false); err != nil {
+			t.Fatalf("failed to zip the archive: %s", err)
+		}
+
+		unzipDir := openTmpDir("zip")
+		got, err := openExistingArchive(zipPth, unzipDir)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if want := filepath.Join(unzipDir, "existing-archive", "App.xcarchive"); got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+
+		archive, err := xcarchive.NewMacosArchive(got)
+		if err != nil {
+			t.Fatalf("failed to open the unzipped archive: %s", err)
+		}
+		if bundleID := archive.Application.BundleIdentifier(); bundleID != "io.bitrise.app" {
+			t.Errorf("got bundle ID %s, want io.bitrise.app", bundleID)
+		}
+		if link, err := os.Readlink(filepath.Join(archive.Application.Path, "Contents", "Frameworks", "Kit.framework", "Versions", "Current")); err != nil || link != "A" {
+			t.Errorf("symlink is not preserved: %q, error: %v", link, err)
+		}
+	}
+
+	t.Log("invalid paths")
+	{
+		notArchiveDir := filepath.Join(tmpDir, "App.app")
+		if err := os.MkdirAll(notArchiveDir, 0755); err != nil {
+			t.Fatalf("failed to create dir: %s", err)
+		}
+		notArchiveFile := filepath.Join(tmpDir, "App.pkg")
+		if err := ioutil.WriteFile(notArchiveFile, []byte("installer package"), 0644); err != nil {
+			t.Fatalf("failed to write file: %s", err)
+		}
+		invalidZip := filepath.Join(tmpDir, "Invalid.xcarchive.zip")
+		if err := ioutil.WriteFile(invalidZip, []byte("not a zip"), 0644); err != nil {
+			t.Fatalf("failed to write file: %s", err)
+		}
+		noArchiveZip := filepath.Join(tmpDir, "NoArchive.xcarchive.zip")
+		if err := ziputil.ZipDir(notArchiveDir, noArchiveZip, false); err != nil {
+			t.Fatalf("failed to zip: %s", err)
+		}
+		createTestArchive(t, filepath.Join(tmpDir, "archives"), "Helper", "1", "1.0")
+		multipleArchivesZip := filepath.Join(tmpDir, "Multiple.xcarchive.zip")
+		if err := ziputil.ZipDir(filepath.Join(tmpDir, "archives"), multipleArchivesZip, true); err != nil {
+			t.Fatalf("failed to zip: %s", err)
+		}
+
+		for _, tt := range []struct {
+			pth     string
+			wantErr string
+		}{
+			{pth: filepath.Join(tmpDir, "Missing.xcarchive"), wantErr: "does not exist"},
+			{pth: filepath.Join(tmpDir, "Missing.xcarchive.zip"), wantErr: "does not exist"},
+			{pth: notArchiveDir, wantErr: "not an xcarchive"},
+			{pth: notArchiveFile, wantErr: "not an xcarchive"},
+			{pth: invalidZip, wantErr: "unzip"},
+			{pth: noArchiveZip, wantErr: "no .xcarchive found in the zip"},
+			{pth: multipleArchivesZip, wantErr: "multiple .xcarchive found in the zip"},
+		} {
+			name := filepath.Base(tt.pth)
+			if _, err := openExistingArchive(tt.pth, openTmpDir(name)); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got error %v, want %s", name, err, tt.wantErr)
+			}
+		}
+	}
+}
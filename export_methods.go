@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-io/go-utils/stringutil"
+	"github.com/bitrise-tools/go-steputils/output"
+	"github.com/bitrise-tools/go-xcode/certificateutil"
+	"github.com/bitrise-tools/go-xcode/export"
+	"github.com/bitrise-tools/go-xcode/exportoptions"
+	"github.com/bitrise-tools/go-xcode/profileutil"
+	"github.com/bitrise-tools/go-xcode/xcarchive"
+	"github.com/bitrise-tools/go-xcode/xcodebuild"
+	"github.com/bitrise-tools/go-xcode/xcpretty"
+)
+
+// exportOutputMutex serializes the output exports of the parallel exports, envman does not support concurrent writes.
+var exportOutputMutex sync.Mutex
+
+// exportMethods returns the export methods of the (newline separated) export_method input.
+func exportMethods(configs ConfigsModel) []string {
+	return splitListInput(configs.ExportMethod)
+}
+
+// exportFormatForMethod returns the extension of the exported product: the app-store export creates an installer package.
+func exportFormatForMethod(method string) string {
+	if method == string(exportoptions.MethodAppStore) {
+		return "pkg"
+	}
+	return "app"
+}
+
+// methodEnvKey returns the method specific variant of the output key, like: BITRISE_APP_PATH_DEVELOPER_ID.
+func methodEnvKey(key, method string) string {
+	return key + "_" + strings.ToUpper(strings.Replace(method, "-", "_", -1))
+}
+
+// methodExport is the export of the archive with one export method.
+type methodExport struct {
+	Method string
+	Format string
+
+	ExportOptionsPath          string
+	FilePath                   string
+	RawXcodebuildOutputLogPath string
+	IDEDistributionLogsZipPath string
+	XcresultPath               string
+	XcresultZipPath            string
+
+	AppEnvKey                 string
+	ExportedFileEnvKey        string
+	XcresultZipEnvKey         string
+	RawResultTextEnvKey       string
+	IDEDistributionLogsEnvKey string
+}
+
+// newMethodExports returns the exports of the export methods. A single method is exported with the step's original
+// paths and outputs, multiple methods are exported with the method in the file names and in the output keys.
+func newMethodExports(configs ConfigsModel, archiveTempDir string) []methodExport {
+	methods := exportMethods(configs)
+
+	exports := []methodExport{}
+	for _, method := range methods {
+		e := methodExport{
+			Method:                     method,
+			Format:                     exportFormatForMethod(method),
+			ExportOptionsPath:          filepath.Join(configs.OutputDir, "export_options.plist"),
+			RawXcodebuildOutputLogPath: filepath.Join(configs.OutputDir, "raw-xcodebuild-output.log"),
+			IDEDistributionLogsZipPath: filepath.Join(configs.OutputDir, "xcodebuild.xcdistributionlogs.zip"),
+			XcresultPath:               filepath.Join(archiveTempDir, configs.ArtifactName+"-export.xcresult"),
+			XcresultZipPath:            filepath.Join(configs.OutputDir, configs.ArtifactName+"-export.xcresult.zip"),
+			AppEnvKey:                  bitriseAppPthEnvKey,
+			ExportedFileEnvKey:         bitriseExportedFilePath,
+			XcresultZipEnvKey:          bitriseExportXcresultZipPthEnvKey,
+			RawResultTextEnvKey:        bitriseXcodeRawResultTextEnvKey,
+			IDEDistributionLogsEnvKey:  bitriseIDEDistributionLogsPthEnvKey,
+		}
+		e.FilePath = filepath.Join(configs.OutputDir, configs.ArtifactName+"."+e.Format)
+
+		if len(methods) > 1 {
+			e.ExportOptionsPath = filepath.Join(configs.OutputDir, "export_options-"+method+".plist")
+			e.RawXcodebuildOutputLogPath = filepath.Join(configs.OutputDir, "raw-xcodebuild-output-"+method+".log")
+			e.IDEDistributionLogsZipPath = filepath.Join(configs.OutputDir, "xcodebuild-"+method+".xcdistributionlogs.zip")
+			e.XcresultPath = filepath.Join(archiveTempDir, configs.ArtifactName+"-export-"+method+".xcresult")
+			e.XcresultZipPath = filepath.Join(configs.OutputDir, configs.ArtifactName+"-export-"+method+".xcresult.zip")
+			e.FilePath = filepath.Join(configs.OutputDir, configs.ArtifactName+"-"+method+"."+e.Format)
+			e.AppEnvKey = methodEnvKey(bitriseAppPthEnvKey, method)
+			e.ExportedFileEnvKey = methodEnvKey(bitriseExportedFilePath, method)
+			e.XcresultZipEnvKey = methodEnvKey(bitriseExportXcresultZipPthEnvKey, method)
+			e.RawResultTextEnvKey = methodEnvKey(bitriseXcodeRawResultTextEnvKey, method)
+			e.IDEDistributionLogsEnvKey = methodEnvKey(bitriseIDEDistributionLogsPthEnvKey, method)
+		}
+
+		exports = append(exports, e)
+	}
+	return exports
+}
+
+// generateExportOptions selects the code signing group (certificate, installer certificate and profiles)
+// for the export method and creates the export options with it.
+func generateExportOptions(archive xcarchive.MacosArchive, method string) (exportoptions.ExportOptions, error) {
+	exportMethod, err := exportoptions.ParseMethod(method)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse export method, error: %s", err)
+	}
+
+	bundleIDEntitlemnstMap := archive.BundleIDEntitlementsMap()
+	bundleIDs := []string{}
+	for bundleID := range bundleIDEntitlemnstMap {
+		bundleIDs = append(bundleIDs, bundleID)
+	}
+
+	installedCertificates, err := certificateutil.InstalledCodesigningCertificateInfos()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get installed certificates, error: %s", err)
+	}
+	installedCertificates = certificateutil.FilterValidCertificateInfos(installedCertificates)
+
+	log.Debugf("\n")
+	log.Debugf("Installed certificates:")
+	for _, certInfo := range installedCertificates {
+		log.Debugf(certInfo.String())
+	}
+
+	installedProfiles, err := profileutil.InstalledProvisioningProfileInfos(profileutil.ProfileTypeMacOs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get installed provisioning profiles, error: %s", err)
+	}
+
+	log.Debugf("\n")
+	log.Debugf("Installed profiles:")
+	for _, profInfo := range installedProfiles {
+		log.Debugf(profInfo.String())
+	}
+
+	codesignGroups := export.CreateSelectableCodeSignGroups(installedCertificates, installedProfiles, bundleIDs)
+	if len(codesignGroups) == 0 {
+		log.Errorf("Failed to find code singing groups for the project")
+	}
+
+	codesignGroups = export.FilterSelectableCodeSignGroups(codesignGroups,
+		export.CreateEntitlementsSelectableCodeSignGroupFilter(bundleIDEntitlemnstMap),
+		export.CreateExportMethodSelectableCodeSignGroupFilter(exportMethod),
+	)
+
+	installedInstallerCertificates := []certificateutil.CertificateInfoModel{}
+
+	if exportMethod == exportoptions.MethodAppStore {
+		installedInstallerCertificates, err = certificateutil.InstalledInstallerCertificateInfos()
+		if err != nil {
+			log.Errorf("Failed to read installed Installer certificates, error: %s", err)
+		}
+
+		installedInstallerCertificates = certificateutil.FilterValidCertificateInfos(installedInstallerCertificates)
+
+		log.Debugf("\n")
+		log.Debugf("Installed installer certificates:")
+		for _, certInfo := range installedInstallerCertificates {
+			log.Debugf(certInfo.String())
+		}
+	}
+
+	var macCodeSignGroup *export.MacCodeSignGroup
+	macCodeSignGroups := export.CreateMacCodeSignGroup(codesignGroups, installedInstallerCertificates, exportMethod)
+	if len(macCodeSignGroups) == 0 {
+		log.Errorf("Can not create macos codesiging groups for the project")
+	} else if len(macCodeSignGroups) > 1 {
+		log.Warnf("Multiple matching  codesiging groups found for the project, using first...")
+		macCodeSignGroup = &(macCodeSignGroups[0])
+	} else {
+		macCodeSignGroup = &(macCodeSignGroups[0])
+	}
+
+	exportProfileMapping := map[string]string{}
+	if macCodeSignGroup != nil {
+		for bundleID, profileInfo := range macCodeSignGroup.BundleIDProfileMap {
+			exportProfileMapping[bundleID] = profileInfo.Name
+		}
+	}
+
+	if exportMethod == exportoptions.MethodAppStore {
+		options := exportoptions.NewAppStoreOptions()
+
+		if macCodeSignGroup != nil {
+			options.BundleIDProvisioningProfileMapping = exportProfileMapping
+			options.SigningCertificate = macCodeSignGroup.Certificate.CommonName
+			options.InstallerSigningCertificate = macCodeSignGroup.InstallerCertificate.CommonName
+		}
+
+		return options, nil
+	}
+
+	options := exportoptions.NewNonAppStoreOptions(exportMethod)
+
+	if macCodeSignGroup != nil {
+		options.BundleIDProvisioningProfileMapping = exportProfileMapping
+		options.SigningCertificate = macCodeSignGroup.Certificate.CommonName
+	}
+
+	return options, nil
+}
+
+// writeExportOptions writes the custom export options or the generated ones to the export's options path.
+func (e methodExport) writeExportOptions(configs ConfigsModel, archive xcarchive.MacosArchive) error {
+	if configs.CustomExportOptionsPlistContent != "" {
+		log.Printf("Custom export options content provided:")
+		fmt.Println(configs.CustomExportOptionsPlistContent)
+
+		if err := fileutil.WriteStringToFile(e.ExportOptionsPath, configs.CustomExportOptionsPlistContent); err != nil {
+			return fmt.Errorf("failed to write export options to file, error: %s", err)
+		}
+		return nil
+	}
+
+	exportOpts, err := generateExportOptions(archive, e.Method)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("generated %s export options content:", e.Method)
+	fmt.Println()
+	fmt.Println(exportOpts.String())
+
+	if err := exportOpts.WriteToFile(e.ExportOptionsPath); err != nil {
+		return fmt.Errorf("failed to write export options to file, error: %s", err)
+	}
+	return nil
+}
+
+// exportFailureLogs exports the raw xcodebuild output (if it was not printed) and the xcdistributionlogs of the failed export.
+func (e methodExport) exportFailureLogs(xcodebuildOut string, isRawOutputHidden bool) {
+	if isRawOutputHidden {
+		if err := output.ExportOutputFileContent(xcodebuildOut, e.RawXcodebuildOutputLogPath, e.RawResultTextEnvKey); err != nil {
+			log.Warnf("Failed to export %s, error: %s", e.RawResultTextEnvKey, err)
+		} else {
+			log.Warnf(`If you can't find the reason of the error in the log, please check the %s
+The log file is stored in $BITRISE_DEPLOY_DIR, and its full path
+is available in the $%s environment variable (value: %s)`, filepath.Base(e.RawXcodebuildOutputLogPath), e.RawResultTextEnvKey, e.RawXcodebuildOutputLogPath)
+		}
+	}
+
+	if logsDirPth, err := findIDEDistrubutionLogsPath(xcodebuildOut); err != nil {
+		log.Warnf("Failed to find xcdistributionlogs, error: %s", err)
+	} else if err := output.ZipAndExportOutput(logsDirPth, e.IDEDistributionLogsZipPath, e.IDEDistributionLogsEnvKey); err != nil {
+		log.Warnf("Failed to export %s, error: %s", e.IDEDistributionLogsEnvKey, err)
+	} else {
+		criticalDistLogFilePth := filepath.Join(logsDirPth, "IDEDistribution.critical.log")
+		log.Warnf("IDEDistribution.critical.log:")
+		if criticalDistLog, err := fileutil.ReadStringFromFile(criticalDistLogFilePth); err == nil {
+			log.Printf(criticalDistLog)
+		}
+
+		log.Warnf(`If you can't find the reason of the error in the log, please check the xcdistributionlogs
+The logs directory is stored in $BITRISE_DEPLOY_DIR, and its full path
+is available in the $%s environment variable (value: %s)`, e.IDEDistributionLogsEnvKey, e.IDEDistributionLogsZipPath)
+	}
+}
+
+// run exports the archive with the export options and exports the exported app (or pkg).
+// The output of the parallel exports is not streamed, it is printed once the export finished.
+func (e methodExport) run(configs ConfigsModel, archivePath string, useResultBundle, isParallel bool, retrier retryPolicy, phase stepPhase) (exportedPathOutputs, error) {
+	outputs := exportedPathOutputs{}
+
+	exportTmpDir, err := pathutil.NormalizedOSTempDirPath("__export__")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create export tmp dir, error: %s", err)
+	}
+
+	exportCmd := xcodebuild.NewExportCommand()
+	exportCmd.SetDir(configs.WorkDir)
+	exportCmd.SetArchivePath(archivePath)
+	exportCmd.SetExportDir(exportTmpDir)
+	exportCmd.SetExportOptionsPlist(e.ExportOptionsPath)
+
+	if useResultBundle {
+		exportCmd.SetResultBundlePath(e.XcresultPath)
+	}
+
+	xcodebuildOut, attempts, err := retrier.run(e.Method+" export", func(attempt uint) (string, error) {
+		if attempt > 0 {
+			// xcodebuild does not overwrite the result bundle of the previous attempt
+			if err := os.RemoveAll(e.XcresultPath); err != nil {
+				return "", fmt.Errorf("failed to remove the result bundle of the previous attempt, error: %s", err)
+			}
+		}
+
+		switch {
+		case isParallel:
+			log.Donef("$ %s", exportCmd.PrintableCmd())
+
+			var outBuffer bytes.Buffer
+			cmd := exportCmd.Command()
+			cmd.SetStdout(&outBuffer)
+			cmd.SetStderr(&outBuffer)
+			err := xcodebuild.RunCommandWithContext(phase.ctx, cmd)
+			return outBuffer.String(), err
+		case configs.OutputTool == "xcpretty":
+			xcprettyCmd := xcpretty.New(exportCmd)
+
+			log.Donef("$ %s", xcprettyCmd.PrintableCmd())
+			fmt.Println()
+
+			return xcprettyCmd.RunWithContext(phase.ctx)
+		default:
+			log.Donef("$ %s", exportCmd.PrintableCmd())
+			fmt.Println()
+
+			return exportCmd.RunAndReturnOutputWithContext(phase.ctx)
+		}
+	})
+	terminated := phase.isDone()
+	err = phase.err(err)
+
+	exportOutputMutex.Lock()
+	defer exportOutputMutex.Unlock()
+
+	printAttemptSummary(e.Method+" export", attempts)
+
+	if err != nil {
+		if isParallel {
+			log.Errorf("\nLast lines of the %s export log:", e.Method)
+			fmt.Println(stringutil.LastNLines(xcodebuildOut, 10))
+		}
+		e.exportFailureLogs(xcodebuildOut, isParallel || configs.OutputTool == "xcpretty" || terminated)
+
+		exportXcresult(e.XcresultPath, e.XcresultZipPath, e.XcresultZipEnvKey)
+		return nil, fmt.Errorf("%s export failed, error: %s%s", e.Method, err, xcresultFailureReason(e.XcresultPath))
+	}
+
+	if pth := exportXcresult(e.XcresultPath, e.XcresultZipPath, e.XcresultZipEnvKey); pth != "" {
+		outputs[e.XcresultZipEnvKey] = pth
+	}
+
+	// find exported app
+	pattern := filepath.Join(exportTmpDir, "*."+e.Format)
+	apps, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find app, with pattern: %s, error: %s", pattern, err)
+	}
+	if len(apps) == 0 {
+		return nil, fmt.Errorf("no exported %s found with pattern: %s", e.Format, pattern)
+	}
+
+	if e.Format == "pkg" {
+		if err := output.ExportOutputFile(apps[0], e.FilePath, e.ExportedFileEnvKey); err != nil {
+			return nil, fmt.Errorf("failed to export %s, error: %s", e.ExportedFileEnvKey, err)
+		}
+		outputs[e.ExportedFileEnvKey] = e.FilePath
+
+		fmt.Println()
+		log.Donef("The pkg path is now available in the Environment Variable: %s (value: %s)", e.ExportedFileEnvKey, e.FilePath)
+	} else if err := exportApp(apps[0], e.FilePath, e.AppEnvKey, e.ExportedFileEnvKey, outputs); err != nil {
+		return nil, err
+	}
+
+	return outputs, nil
+}
+
+// exportArchive exports the archive with every export method, multiple methods are exported in parallel.
+func exportArchive(configs ConfigsModel, archive xcarchive.MacosArchive, exports []methodExport, useResultBundle bool, retrier retryPolicy, phase stepPhase) (exportedPathOutputs, error) {
+	// the export does not use the DerivedData, which may be in use by the parallel exports and archive jobs
+	retrier.cleanDerivedData = false
+
+	for _, e := range exports {
+		if err := e.writeExportOptions(configs, archive); err != nil {
+			return nil, fmt.Errorf("%s export options, error: %s", e.Method, err)
+		}
+	}
+
+	isParallel := len(exports) > 1
+	if isParallel {
+		methods := []string{}
+		for _, e := range exports {
+			methods = append(methods, e.Method)
+		}
+		log.Printf("Exporting %s in parallel...", strings.Join(methods, ", "))
+		fmt.Println()
+	}
+
+	results := make([]exportedPathOutputs, len(exports))
+	errs := make([]error, len(exports))
+	var wg sync.WaitGroup
+	for i, e := range exports {
+		wg.Add(1)
+		go func(i int, e methodExport) {
+			defer wg.Done()
+			results[i], errs[i] = e.run(configs, archive.Path, useResultBundle, isParallel, retrier, phase)
+		}(i, e)
+	}
+	wg.Wait()
+
+	failed := []string{}
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(failed, "\n"))
+	}
+
+	outputs := exportedPathOutputs{}
+	for _, result := range results {
+		for key, pth := range result {
+			outputs[key] = pth
+		}
+	}
+	return outputs, nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-tools/go-xcode/xcarchive"
+)
+
+// fakeExportXcodebuildScript exports an app (with a symlink, like the frameworks' Versions/Current) and a pkg
+// into the -exportPath, or fails if the export options contain the word "fail" (or "locked", with a transient failure).
+const fakeExportXcodebuildScript = `#!/bin/sh
+while [ $# -gt 0 ]; do
+  case "$1" in
+    -exportPath) export_path="$2"; shift ;;
+    -exportOptionsPlist) options="$2"; shift ;;
+  esac
+  shift
+done
+
+if grep -q locked "$options"; then
+  echo "error: unable to attach DB: error: accessing build database \"build.db\": database is locked"
+  exit 65
+fi
+
+if grep -q fail "$options"; then
+  echo "error: exportArchive: No signing certificate \"Developer ID Application\" found"
+  echo "** EXPORT FAILED **"
+  exit 70
+fi
+
+mkdir -p "$export_path/App.app/Contents/MacOS"
+echo "binary" > "$export_path/App.app/Contents/MacOS/App"
+ln -s MacOS "$export_path/App.app/Contents/Current"
+echo "installer" > "$export_path/App.pkg"
+echo "** EXPORT SUCCEEDED **"
+`
+
+// setupFakeEnvman puts a fake envman in the PATH, which writes the exported environment variables
+// into the returned file. The fake tools of the tests are written into the returned bin dir.
+func setupFakeEnvman(t *testing.T, tmpDir string) (string, string, func()) {
+	binDir := filepath.Join(tmpDir, "bin")
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		t.Fatalf("failed to create bin dir: %s", err)
+	}
+
+	envstorePth := filepath.Join(tmpDir, "envstore")
+	envmanScript := "#!/bin/sh\necho \"$3=$(cat)\" >> \"" + envstorePth + "\"\n"
+	if err := ioutil.WriteFile(filepath.Join(binDir, "envman"), []byte(envmanScript), 0755); err != nil {
+		t.Fatalf("failed to write fake envman: %s", err)
+	}
+
+	return binDir, envstorePth, setenvForTest(t, "PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// setupFakeExportTools puts a fake xcodebuild and envman in the PATH, the envman writes the exported
+// environment variables into the returned file.
+func setupFakeExportTools(t *testing.T, tmpDir string) (string, func()) {
+	if _, err := exec.LookPath("rsync"); err != nil {
+		t.Skip("rsync is required to copy the exported app")
+	}
+
+	binDir, envstorePth, restore := setupFakeEnvman(t, tmpDir)
+	if err := ioutil.WriteFile(filepath.Join(binDir, "xcodebuild"), []byte(fakeExportXcodebuildScript), 0755); err != nil {
+		restore()
+		t.Fatalf("failed to write fake xcodebuild: %s", err)
+	}
+	return envstorePth, restore
+}
+
+func readFakeEnvstore(t *testing.T, pth string) []string {
+	content, err := ioutil.ReadFile(pth)
+	if err != nil {
+		t.Fatalf("failed to read envstore: %s", err)
+	}
+	envs := strings.Split(strings.TrimSpace(string(content)), "\n")
+	sort.Strings(envs)
+	return envs
+}
+
+func zipEntries(t *testing.T, pth string) []string {
+	reader, err := zip.OpenReader(pth)
+	if err != nil {
+		t.Fatalf("failed to open zip: %s", err)
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			t.Logf("failed to close zip: %s", err)
+		}
+	}()
+
+	entries := []string{}
+	for _, file := range reader.File {
+		entries = append(entries, file.Name)
+	}
+	return entries
+}
+
+func TestExportArchive(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Logf("failed to remove tmp dir: %s", err)
+		}
+	}()
+
+	envstorePth, restore := setupFakeExportTools(t, tmpDir)
+	defer restore()
+
+	archive := xcarchive.MacosArchive{Path: filepath.Join(tmpDir, "App.xcarchive")}
+	newConfigs := func(name, exportMethod, exportOptions string) ConfigsModel {
+		outputDir := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			t.Fatalf("failed to create output dir: %s", err)
+		}
+		if err := os.RemoveAll(envstorePth); err != nil {
+			t.Fatalf("failed to clean envstore: %s", err)
+		}
+		return ConfigsModel{
+			ExportMethod:                    exportMethod,
+			CustomExportOptionsPlistContent: exportOptions,
+			OutputTool:                      "xcodebuild",
+			OutputDir:                       outputDir,
+			ArtifactName:                    "App",
+		}
+	}
+
+	t.Log("single method: the app is copied and zipped from the copy")
+	{
+		configs := newConfigs("single", "developer-id", testDeveloperIDExportOptionsContent)
+		exports := newMethodExports(configs, tmpDir)
+
+		outputs, err := exportArchive(configs, archive, exports, false, retryPolicy{}, newStepPhase(context.Background(), "export", 0))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		appPth := filepath.Join(configs.OutputDir, "App.app")
+		want := exportedPathOutputs{
+			bitriseAppPthEnvKey:     appPth,
+			bitriseExportedFilePath: appPth + ".zip",
+		}
+		if !reflect.DeepEqual(outputs, want) {
+			t.Fatalf("got outputs %v, want %v", outputs, want)
+		}
+		if err := outputs.verify(); err != nil {
+			t.Errorf("unexpected verify error: %s", err)
+		}
+
+		if content, err := ioutil.ReadFile(filepath.Join(appPth, "Contents", "MacOS", "App")); err != nil || string(content) != "binary\n" {
+			t.Errorf("unexpected app binary content: %q, error: %v", content, err)
+		}
+		if link, err := os.Readlink(filepath.Join(appPth, "Contents", "Current")); err != nil || link != "MacOS" {
+			t.Errorf("symlink is not preserved: %q, error: %v", link, err)
+		}
+
+		entries := zipEntries(t, appPth+".zip")
+		for _, entry := range []string{"App.app/Contents/MacOS/App", "App.app/Contents/Current"} {
+			found := false
+			for _, e := range entries {
+				found = found || e == entry
+			}
+			if !found {
+				t.Errorf("%s not found in the zip: %v", entry, entries)
+			}
+		}
+
+		if content, err := ioutil.ReadFile(exports[0].ExportOptionsPath); err != nil || string(content) != testDeveloperIDExportOptionsContent {
+			t.Errorf("unexpected export options: %q, error: %v", content, err)
+		}
+
+		wantEnvs := []string{bitriseAppPthEnvKey + "=" + appPth, bitriseExportedFilePath + "=" + appPth + ".zip"}
+		if envs := readFakeEnvstore(t, envstorePth); !reflect.DeepEqual(envs, wantEnvs) {
+			t.Errorf("got envs %q, want %q", envs, wantEnvs)
+		}
+
+		// a removed output fails the output check
+		if err := os.RemoveAll(appPth + ".zip"); err != nil {
+			t.Fatalf("failed to remove zip: %s", err)
+		}
+		if err := outputs.verify(); err == nil || !strings.Contains(err.Error(), bitriseExportedFilePath) {
+			t.Errorf("expected verify error for the removed zip, got: %v", err)
+		}
+	}
+
+	t.Log("multiple methods are exported in parallel with method specific outputs")
+	{
+		configs := newConfigs("multiple", "developer-id\napp-store", testDeveloperIDExportOptionsContent)
+		exports := newMethodExports(configs, tmpDir)
+
+		outputs, err := exportArchive(configs, archive, exports, false, retryPolicy{}, newStepPhase(context.Background(), "export", 0))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		appPth := filepath.Join(configs.OutputDir, "App-developer-id.app")
+		want := exportedPathOutputs{
+			"BITRISE_APP_PATH_DEVELOPER_ID":           appPth,
+			"BITRISE_EXPORTED_FILE_PATH_DEVELOPER_ID": appPth + ".zip",
+			"BITRISE_EXPORTED_FILE_PATH_APP_STORE":    filepath.Join(configs.OutputDir, "App-app-store.pkg"),
+		}
+		if !reflect.DeepEqual(outputs, want) {
+			t.Fatalf("got outputs %v, want %v", outputs, want)
+		}
+		if err := outputs.verify(); err != nil {
+			t.Errorf("unexpected verify error: %s", err)
+		}
+		if envs := readFakeEnvstore(t, envstorePth); len(envs) != 3 {
+			t.Errorf("unexpected envs: %q", envs)
+		}
+	}
+
+	t.Log("failed export")
+	{
+		configs := newConfigs("failed", "developer-id", strings.Replace(testDeveloperIDExportOptionsContent, "developer-id", "fail", -1))
+		exports := newMethodExports(configs, tmpDir)
+
+		outputs, err := exportArchive(configs, archive, exports, false, retryPolicy{}, newStepPhase(context.Background(), "export", 0))
+		if err == nil {
+			t.Fatalf("expected error for the failed export")
+		}
+		if !strings.Contains(err.Error(), "developer-id export failed") {
+			t.Errorf("unexpected error: %s", err)
+		}
+		if len(outputs) != 0 {
+			t.Errorf("unexpected outputs: %v", outputs)
+		}
+		if exist, _ := pathutil.IsPathExists(filepath.Join(configs.OutputDir, "App.app")); exist {
+			t.Errorf("app exported for the failed export")
+		}
+	}
+
+	t.Log("failed parallel exports export their raw logs with method specific keys")
+	{
+		configs := newConfigs("failed-multiple", "developer-id\ndevelopment", strings.Replace(testDeveloperIDExportOptionsContent, "developer-id", "fail", -1))
+		exports := newMethodExports(configs, tmpDir)
+
+		if _, err := exportArchive(configs, archive, exports, false, retryPolicy{}, newStepPhase(context.Background(), "export", 0)); err == nil {
+			t.Fatalf("expected error for the failed exports")
+		}
+
+		wantEnvs := []string{
+			"BITRISE_XCODE_RAW_RESULT_TEXT_PATH_DEVELOPER_ID=" + filepath.Join(configs.OutputDir, "raw-xcodebuild-output-developer-id.log"),
+			"BITRISE_XCODE_RAW_RESULT_TEXT_PATH_DEVELOPMENT=" + filepath.Join(configs.OutputDir, "raw-xcodebuild-output-development.log"),
+		}
+		if envs := readFakeEnvstore(t, envstorePth); !reflect.DeepEqual(envs, wantEnvs) {
+			t.Errorf("got envs %q, want %q", envs, wantEnvs)
+		}
+	}
+
+	t.Log("export retries do not clean the DerivedData")
+	{
+		derivedDataPath := filepath.Join(tmpDir, "DerivedData")
+		if err := os.MkdirAll(filepath.Join(derivedDataPath, "Build"), 0755); err != nil {
+			t.Fatalf("failed to create DerivedData: %s", err)
+		}
+
+		configs := newConfigs("locked", "developer-id\ndevelopment", strings.Replace(testDeveloperIDExportOptionsContent, "developer-id", "locked", -1))
+		exports := newMethodExports(configs, tmpDir)
+
+		retrier := retryPolicy{retries: 1, cleanDerivedData: true, derivedDataPath: derivedDataPath}
+		if _, err := exportArchive(configs, archive, exports, false, retrier, newStepPhase(context.Background(), "export", 0)); err == nil {
+			t.Fatalf("expected error for the failed exports")
+		}
+		if exist, _ := pathutil.IsDirExists(derivedDataPath); !exist {
+			t.Errorf("DerivedData is cleaned by the export retry")
+		}
+	}
+}
+
+const testDeveloperIDExportOptionsContent = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>method</key>
+	<string>developer-id</string>
+</dict>
+</plist>
+`
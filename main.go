@@ -2,27 +2,26 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/bitrise-io/go-utils/command"
-	"github.com/bitrise-io/go-utils/fileutil"
 	"github.com/bitrise-io/go-utils/log"
 	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-io/go-utils/sliceutil"
 	"github.com/bitrise-io/go-utils/stringutil"
 	"github.com/bitrise-tools/go-steputils/input"
 	"github.com/bitrise-tools/go-steputils/output"
 	"github.com/bitrise-tools/go-steputils/tools"
-	"github.com/bitrise-tools/go-xcode/certificateutil"
-	"github.com/bitrise-tools/go-xcode/export"
-	"github.com/bitrise-tools/go-xcode/exportoptions"
-	"github.com/bitrise-tools/go-xcode/profileutil"
 	"github.com/bitrise-tools/go-xcode/utility"
 	"github.com/bitrise-tools/go-xcode/xcarchive"
 	"github.com/bitrise-tools/go-xcode/xcodebuild"
+	"github.com/bitrise-tools/go-xcode/xcodeproj"
 	"github.com/bitrise-tools/go-xcode/xcpretty"
 )
 
@@ -34,12 +33,15 @@ const (
 	bitriseXCArchiveDirPthEnvKey        = "BITRISE_MACOS_XCARCHIVE_PATH"
 	bitriseAppPthEnvKey                 = "BITRISE_APP_PATH"
 	bitriseIDEDistributionLogsPthEnvKey = "BITRISE_IDEDISTRIBUTION_LOGS_PATH"
+	bitriseXcresultZipPthEnvKey         = "BITRISE_XCRESULT_ZIP_PATH"
+	bitriseExportXcresultZipPthEnvKey   = "BITRISE_EXPORT_XCRESULT_ZIP_PATH"
 )
 
 // ConfigsModel ...
 type ConfigsModel struct {
 	ExportMethod                    string
 	CustomExportOptionsPlistContent string
+	IsArchiveOnly                   string
 
 	ProjectPath   string
 	Scheme        string
@@ -47,10 +49,38 @@ type ConfigsModel struct {
 	IsCleanBuild  string
 	WorkDir       string
 
+	MaxConcurrentArchives string
+
+	ArchivePath string
+
+	XcodebuildOptions       string
+	XcodebuildBuildSettings string
+
+	BuildNumber         string
+	BuildNumberOffset   string
+	MarketingVersion    string
+	VersionUpdateMethod string
+
 	ForceTeamID                       string
 	ForceCodeSignIdentity             string
 	ForceProvisioningProfileSpecifier string
 	ForceProvisioningProfile          string
+	CodeSignCheck                     string
+
+	IsCacheSwiftPackages     string
+	ClonedSourcePackagesPath string
+
+	DerivedDataPath    string
+	IsCacheDerivedData string
+
+	DependencyCheck string
+
+	RetryCount                string
+	RetryWaitSeconds          string
+	IsCleanDerivedDataOnRetry string
+
+	ArchiveTimeoutSeconds string
+	ExportTimeoutSeconds  string
 
 	OutputTool           string
 	OutputDir            string
@@ -64,6 +94,7 @@ func createConfigsModelFromEnvs() ConfigsModel {
 	return ConfigsModel{
 		ExportMethod:                    os.Getenv("export_method"),
 		CustomExportOptionsPlistContent: os.Getenv("custom_export_options_plist_content"),
+		IsArchiveOnly:                   os.Getenv("is_archive_only"),
 
 		ProjectPath:   os.Getenv("project_path"),
 		Scheme:        os.Getenv("scheme"),
@@ -71,10 +102,38 @@ func createConfigsModelFromEnvs() ConfigsModel {
 		IsCleanBuild:  os.Getenv("is_clean_build"),
 		WorkDir:       os.Getenv("workdir"),
 
+		MaxConcurrentArchives: os.Getenv("max_concurrent_archives"),
+
+		ArchivePath: os.Getenv("archive_path"),
+
+		XcodebuildOptions:       os.Getenv("xcodebuild_options"),
+		XcodebuildBuildSettings: os.Getenv("xcodebuild_build_settings"),
+
+		BuildNumber:         os.Getenv("build_number"),
+		BuildNumberOffset:   os.Getenv("build_number_offset"),
+		MarketingVersion:    os.Getenv("marketing_version"),
+		VersionUpdateMethod: os.Getenv("version_update_method"),
+
 		ForceTeamID:                       os.Getenv("force_team_id"),
 		ForceCodeSignIdentity:             os.Getenv("force_code_sign_identity"),
 		ForceProvisioningProfileSpecifier: os.Getenv("force_provisioning_profile_specifier"),
 		ForceProvisioningProfile:          os.Getenv("force_provisioning_profile"),
+		CodeSignCheck:                     os.Getenv("code_sign_check"),
+
+		IsCacheSwiftPackages:     os.Getenv("is_cache_swift_packages"),
+		ClonedSourcePackagesPath: os.Getenv("cloned_source_packages_path"),
+
+		DerivedDataPath:    os.Getenv("derived_data_path"),
+		IsCacheDerivedData: os.Getenv("is_cache_derived_data"),
+
+		DependencyCheck: os.Getenv("dependency_check"),
+
+		RetryCount:                os.Getenv("retry_count"),
+		RetryWaitSeconds:          os.Getenv("retry_wait_seconds"),
+		IsCleanDerivedDataOnRetry: os.Getenv("is_clean_derived_data_on_retry"),
+
+		ArchiveTimeoutSeconds: os.Getenv("archive_timeout_seconds"),
+		ExportTimeoutSeconds:  os.Getenv("export_timeout_seconds"),
 
 		OutputTool:           os.Getenv("output_tool"),
 		OutputDir:            os.Getenv("output_dir"),
@@ -103,6 +162,7 @@ func (configs ConfigsModel) print() {
 	if useCustomExportOptions {
 		log.Warnf("----------")
 	}
+	log.Printf("- IsArchiveOnly: %s", configs.IsArchiveOnly)
 
 	log.Infof("xcodebuild configs:")
 	log.Printf("- ProjectPath: %s", configs.ProjectPath)
@@ -110,12 +170,46 @@ func (configs ConfigsModel) print() {
 	log.Printf("- Configuration: %s", configs.Configuration)
 	log.Printf("- IsCleanBuild: %s", configs.IsCleanBuild)
 	log.Printf("- WorkDir: %s", configs.WorkDir)
+	log.Printf("- MaxConcurrentArchives: %s", configs.MaxConcurrentArchives)
+	log.Printf("- ArchivePath: %s", configs.ArchivePath)
+	log.Printf("- XcodebuildOptions: %s", configs.XcodebuildOptions)
+	log.Printf("- XcodebuildBuildSettings:")
+	if configs.XcodebuildBuildSettings != "" {
+		log.Printf("%s", configs.XcodebuildBuildSettings)
+	}
+
+	log.Infof("versioning configs:")
+	log.Printf("- BuildNumber: %s", configs.BuildNumber)
+	log.Printf("- BuildNumberOffset: %s", configs.BuildNumberOffset)
+	log.Printf("- MarketingVersion: %s", configs.MarketingVersion)
+	log.Printf("- VersionUpdateMethod: %s", configs.VersionUpdateMethod)
 
 	log.Infof("force archive codesign settings:")
 	log.Printf("- ForceTeamID: %s", configs.ForceTeamID)
 	log.Printf("- ForceCodeSignIdentity: %s", configs.ForceCodeSignIdentity)
 	log.Printf("- ForceProvisioningProfileSpecifier: %s", configs.ForceProvisioningProfileSpecifier)
 	log.Printf("- ForceProvisioningProfile: %s", configs.ForceProvisioningProfile)
+	log.Printf("- CodeSignCheck: %s", configs.CodeSignCheck)
+
+	log.Infof("swift package configs:")
+	log.Printf("- IsCacheSwiftPackages: %s", configs.IsCacheSwiftPackages)
+	log.Printf("- ClonedSourcePackagesPath: %s", configs.ClonedSourcePackagesPath)
+
+	log.Infof("derived data configs:")
+	log.Printf("- DerivedDataPath: %s", configs.DerivedDataPath)
+	log.Printf("- IsCacheDerivedData: %s", configs.IsCacheDerivedData)
+
+	log.Infof("dependency configs:")
+	log.Printf("- DependencyCheck: %s", configs.DependencyCheck)
+
+	log.Infof("retry configs:")
+	log.Printf("- RetryCount: %s", configs.RetryCount)
+	log.Printf("- RetryWaitSeconds: %s", configs.RetryWaitSeconds)
+	log.Printf("- IsCleanDerivedDataOnRetry: %s", configs.IsCleanDerivedDataOnRetry)
+
+	log.Infof("timeout configs:")
+	log.Printf("- ArchiveTimeoutSeconds: %s", configs.ArchiveTimeoutSeconds)
+	log.Printf("- ExportTimeoutSeconds: %s", configs.ExportTimeoutSeconds)
 
 	log.Infof("step output configs:")
 	log.Printf("- OutputTool: %s", configs.OutputTool)
@@ -126,17 +220,83 @@ func (configs ConfigsModel) print() {
 	log.Printf("- VerboseLog: %s", configs.VerboseLog)
 }
 
+// resolvePathInWorkDir expands the given path and resolves it against the working directory, if it is relative.
+func resolvePathInWorkDir(pth, workDir string) (string, error) {
+	if pth == "" {
+		return "", nil
+	}
+
+	expandedPth, err := pathutil.ExpandTilde(pth)
+	if err != nil {
+		return "", err
+	}
+	expandedPth = os.ExpandEnv(expandedPth)
+
+	if filepath.IsAbs(expandedPth) {
+		return filepath.Clean(expandedPth), nil
+	}
+	if workDir != "" {
+		return filepath.Join(workDir, expandedPth), nil
+	}
+	return pathutil.AbsPath(expandedPth)
+}
+
+// resolvePaths validates the working directory and resolves the relative path inputs against it.
+func (configs *ConfigsModel) resolvePaths() error {
+	if configs.WorkDir != "" {
+		absWorkDir, err := pathutil.AbsPath(configs.WorkDir)
+		if err != nil {
+			return fmt.Errorf("WorkDir - failed to expand path (%s), error: %s", configs.WorkDir, err)
+		}
+		if err := input.ValidateIfDirExists(absWorkDir); err != nil {
+			return fmt.Errorf("WorkDir - %s", err)
+		}
+		configs.WorkDir = absWorkDir
+	}
+
+	pathInputs := map[string]*string{
+		"ProjectPath":              &configs.ProjectPath,
+		"ArchivePath":              &configs.ArchivePath,
+		"OutputDir":                &configs.OutputDir,
+		"ClonedSourcePackagesPath": &configs.ClonedSourcePackagesPath,
+		"DerivedDataPath":          &configs.DerivedDataPath,
+	}
+	for name, pth := range pathInputs {
+		resolvedPth, err := resolvePathInWorkDir(*pth, configs.WorkDir)
+		if err != nil {
+			return fmt.Errorf("%s - failed to expand path (%s), error: %s", name, *pth, err)
+		}
+		*pth = resolvedPth
+	}
+
+	return nil
+}
+
 func (configs ConfigsModel) validate() error {
-	if err := input.ValidateIfPathExists(configs.ProjectPath); err != nil {
-		return fmt.Errorf("ProjectPath - %s", err)
+	if configs.ProjectPath != "" {
+		if err := input.ValidateIfPathExists(configs.ProjectPath); err != nil {
+			return fmt.Errorf("ProjectPath - %s", err)
+		}
 	}
 
-	if err := input.ValidateIfPathExists(configs.OutputDir); err != nil {
-		return fmt.Errorf("OutputDir - %s", err)
+	if err := input.ValidateWithOptions(configs.IsArchiveOnly, "yes", "no"); err != nil {
+		return fmt.Errorf("IsArchiveOnly - %s", err)
+	}
+
+	if configs.ArchivePath != "" {
+		if configs.IsArchiveOnly == "yes" {
+			return fmt.Errorf("ArchivePath - an existing archive can not be used in archive-only mode, there is nothing to do")
+		}
+		if err := input.ValidateIfPathExists(configs.ArchivePath); err != nil {
+			return fmt.Errorf("ArchivePath - %s", err)
+		}
+		if len(splitListInput(configs.Scheme)) > 1 {
+			return fmt.Errorf("ArchivePath - an existing archive can not be exported with multiple schemes")
+		}
 	}
 
-	if err := input.ValidateIfNotEmpty(configs.Scheme); err != nil {
-		return fmt.Errorf("Scheme - %s", err)
+	if err := input.ValidateIfPathExists(configs.OutputDir); err != nil {
+		return fmt.Errorf("OutputDir - %s", err)
 	}
 
 	if err := input.ValidateWithOptions(configs.OutputTool, "xcpretty", "xcodebuild"); err != nil {
@@ -147,6 +307,10 @@ func (configs ConfigsModel) validate() error {
 		return fmt.Errorf("IsCleanBuild - %s", err)
 	}
 
+	if concurrency, err := strconv.Atoi(configs.MaxConcurrentArchives); err != nil || concurrency < 1 {
+		return fmt.Errorf("MaxConcurrentArchives - should be a positive integer, got: %s", configs.MaxConcurrentArchives)
+	}
+
 	if err := input.ValidateWithOptions(configs.IsExportXcarchiveZip, "yes", "no"); err != nil {
 		return fmt.Errorf("IsExportXcarchiveZip - %s", err)
 	}
@@ -155,22 +319,100 @@ func (configs ConfigsModel) validate() error {
 		return fmt.Errorf("IsExportAllDsyms - %s", err)
 	}
 
-	if err := input.ValidateWithOptions(configs.ExportMethod, "none", "app-store", "development", "developer-id"); err != nil {
-		return fmt.Errorf("ExportMethod - %s", err)
+	if err := input.ValidateWithOptions(configs.IsCacheSwiftPackages, "yes", "no"); err != nil {
+		return fmt.Errorf("IsCacheSwiftPackages - %s", err)
+	}
+
+	if configs.IsCacheSwiftPackages == "yes" {
+		if err := input.ValidateIfNotEmpty(configs.ClonedSourcePackagesPath); err != nil {
+			return fmt.Errorf("ClonedSourcePackagesPath - %s", err)
+		}
+	}
+
+	if err := input.ValidateWithOptions(configs.IsCacheDerivedData, "yes", "no"); err != nil {
+		return fmt.Errorf("IsCacheDerivedData - %s", err)
+	}
+
+	if err := input.ValidateWithOptions(configs.CodeSignCheck, codeSignCheckFail, codeSignCheckWarn, codeSignCheckNone); err != nil {
+		return fmt.Errorf("CodeSignCheck - %s", err)
+	}
+
+	if err := input.ValidateWithOptions(configs.DependencyCheck, dependencyCheckFail, dependencyCheckWarn, dependencyCheckNone); err != nil {
+		return fmt.Errorf("DependencyCheck - %s", err)
+	}
+
+	if retries, err := strconv.Atoi(configs.RetryCount); err != nil || retries < 0 {
+		return fmt.Errorf("RetryCount - should be a non-negative integer, got: %s", configs.RetryCount)
 	}
 
-	if err := input.ValidateIfNotEmpty(configs.ArtifactName); err != nil {
-		return fmt.Errorf("ArtifactName - %s", err)
+	if wait, err := strconv.Atoi(configs.RetryWaitSeconds); err != nil || wait < 0 {
+		return fmt.Errorf("RetryWaitSeconds - should be a non-negative integer, got: %s", configs.RetryWaitSeconds)
+	}
+
+	if err := input.ValidateWithOptions(configs.IsCleanDerivedDataOnRetry, "yes", "no"); err != nil {
+		return fmt.Errorf("IsCleanDerivedDataOnRetry - %s", err)
+	}
+
+	if timeout, err := strconv.Atoi(configs.ArchiveTimeoutSeconds); err != nil || timeout < 0 {
+		return fmt.Errorf("ArchiveTimeoutSeconds - should be a non-negative integer, got: %s", configs.ArchiveTimeoutSeconds)
+	}
+
+	if timeout, err := strconv.Atoi(configs.ExportTimeoutSeconds); err != nil || timeout < 0 {
+		return fmt.Errorf("ExportTimeoutSeconds - should be a non-negative integer, got: %s", configs.ExportTimeoutSeconds)
+	}
+
+	methods := exportMethods(configs)
+	if len(methods) == 0 {
+		return fmt.Errorf("ExportMethod - required variable is not present")
+	}
+	for _, method := range methods {
+		if err := input.ValidateWithOptions(method, "none", "app-store", "development", "developer-id"); err != nil {
+			return fmt.Errorf("ExportMethod - %s", err)
+		}
+	}
+	if len(methods) > 1 {
+		if sliceutil.IsStringInSlice("none", methods) {
+			return fmt.Errorf("ExportMethod - none can not be combined with other export methods")
+		}
+		if configs.CustomExportOptionsPlistContent != "" {
+			return fmt.Errorf("ExportMethod - multiple export methods can not be used with CustomExportOptionsPlistContent")
+		}
+	}
+
+	if err := input.ValidateWithOptions(configs.VersionUpdateMethod, versionUpdateMethodBuildSettings, versionUpdateMethodInfoPlist); err != nil {
+		return fmt.Errorf("VersionUpdateMethod - %s", err)
+	}
+
+	if err := validateBundleVersion(configs.MarketingVersion); err != nil {
+		return fmt.Errorf("MarketingVersion - %s", err)
 	}
 
 	return nil
 }
 
+// timedOutExitCode is the step's exit code, if it failed because of a timeout (the same as the timeout command's).
+const timedOutExitCode = 124
+
 func failf(format string, v ...interface{}) {
 	log.Errorf(format, v...)
 	os.Exit(1)
 }
 
+func timedOutf(format string, v ...interface{}) {
+	log.Errorf(format, v...)
+	os.Exit(timedOutExitCode)
+}
+
+// failIfDone fails the step, if it was interrupted or timed out during the stage.
+func failIfDone(ctx context.Context, stage string) {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		timedOutf("%s timed out", stage)
+	case context.Canceled:
+		failf("%s interrupted", stage)
+	}
+}
+
 func getXcprettyVersion() (string, error) {
 	cmd := command.New("xcpretty", "-version")
 	return cmd.RunAndReturnTrimmedCombinedOutput()
@@ -197,14 +439,116 @@ func findIDEDistrubutionLogsPath(output string) (string, error) {
 func main() {
 	configs := createConfigsModelFromEnvs()
 	configs.print()
+	if err := configs.resolvePaths(); err != nil {
+		failf("Issue with input: %s", err)
+	}
 	if err := configs.validate(); err != nil {
 		failf("Issue with input: %s", err)
 	}
 
 	log.SetEnableDebugLog(configs.VerboseLog == "yes")
 
+	// SIGINT and SIGTERM terminate the running xcodebuild and xcpretty commands, instead of leaving them behind
+	ctx := interruptContext()
+
+	// Multiple schemes are archived by running the step for each of them
+	jobs, err := newArchiveJobs(configs)
+	if err != nil {
+		failf("Issue with input: %s", err)
+	}
+	if len(jobs) > 0 {
+		if err := runArchiveJobs(ctx, configs, jobs); err != nil {
+			if jobsErr, ok := err.(archiveJobsError); ok && jobsErr.timedOut {
+				timedOutf("Failed to archive the schemes, error: %s", err)
+			}
+			failf("Failed to archive the schemes, error: %s", err)
+		}
+		return
+	}
+	configs.Scheme = strings.TrimSpace(configs.Scheme)
+	configs.Configuration = strings.TrimSpace(configs.Configuration)
+
 	log.Infof("step determined configs:")
 
+	buildNumber, err := resolveBuildNumber(configs.BuildNumber, configs.BuildNumberOffset)
+	if err != nil {
+		failf("Issue with input: BuildNumber - %s", err)
+	}
+	if err := validateBundleVersion(buildNumber); err != nil {
+		failf("Issue with input: BuildNumber - %s", err)
+	}
+	if buildNumber != configs.BuildNumber {
+		log.Printf("- build_number: %s (%s + %s)", buildNumber, configs.BuildNumber, configs.BuildNumberOffset)
+	}
+	configs.BuildNumber = buildNumber
+
+	if configs.ArchivePath != "" {
+		// Export-only mode: the project is not archived, the existing archive is exported
+		if configs.ArtifactName == "" {
+			configs.ArtifactName = existingArchiveName(configs.ArchivePath)
+			log.Printf("- artifact_name: %s", configs.ArtifactName)
+		}
+	} else {
+		// Detect project and scheme
+		if configs.ProjectPath == "" {
+			searchDir := configs.WorkDir
+			if searchDir == "" {
+				searchDir = "."
+			}
+
+			projectPath, err := detectProjectPath(searchDir)
+			if err != nil {
+				failf("ProjectPath not set and failed to detect it, error: %s", err)
+			}
+			absProjectPath, err := pathutil.AbsPath(projectPath)
+			if err != nil {
+				failf("Failed to expand ProjectPath (%s), error: %s", projectPath, err)
+			}
+			configs.ProjectPath = absProjectPath
+			log.Printf("- detected project_path: %s", configs.ProjectPath)
+		}
+
+		if configs.Scheme == "" {
+			scheme, err := detectScheme(configs.ProjectPath)
+			if err != nil {
+				failf("Scheme not set and failed to detect it, error: %s", err)
+			}
+			configs.Scheme = scheme
+			log.Printf("- detected scheme: %s", configs.Scheme)
+		}
+
+		if configs.ArtifactName == "" {
+			configs.ArtifactName = configs.Scheme
+			log.Printf("- artifact_name: %s", configs.ArtifactName)
+		}
+
+		// Resolve configuration from the scheme's ArchiveAction
+		scheme, schemeContainerPath, err := xcodeproj.FindScheme(configs.ProjectPath, configs.Scheme, os.Getenv("USER"))
+		if err != nil {
+			log.Warnf("Failed to read scheme, error: %s", err)
+		} else {
+			log.Printf("- scheme_path: %s", scheme.Path)
+			log.Printf("- reveal_archive_in_organizer: %v", scheme.ArchiveAction.IsRevealArchiveInOrganizer())
+			for _, reference := range scheme.ArchivableBuildableReferences() {
+				log.Printf("- archivable target: %s (%s)", reference.BlueprintName, reference.ReferencedContainerAbsPath(filepath.Dir(schemeContainerPath)))
+			}
+		}
+
+		if configs.Configuration == "" {
+			if scheme.ArchiveAction.BuildConfiguration == "" {
+				log.Warnf("Configuration not set and failed to read the scheme's archive action configuration, xcodebuild will use the scheme's default")
+			} else {
+				configs.Configuration = scheme.ArchiveAction.BuildConfiguration
+				log.Printf("- configuration: %s (scheme's archive action configuration)", configs.Configuration)
+			}
+		} else {
+			log.Printf("- configuration: %s", configs.Configuration)
+			if scheme.ArchiveAction.BuildConfiguration != "" && scheme.ArchiveAction.BuildConfiguration != configs.Configuration {
+				log.Warnf("Configuration (%s) overrides the scheme's archive action configuration (%s)", configs.Configuration, scheme.ArchiveAction.BuildConfiguration)
+			}
+		}
+	}
+
 	// Detect Xcode major version
 	xcodebuildVersion, err := utility.GetXcodeVersion()
 	if err != nil {
@@ -247,24 +591,122 @@ func main() {
 		configs.ForceProvisioningProfile = ""
 	}
 
-	// Project-or-Workspace flag
-	action := ""
-	if strings.HasSuffix(configs.ProjectPath, ".xcodeproj") {
-		action = "-project"
-	} else if strings.HasSuffix(configs.ProjectPath, ".xcworkspace") {
-		action = "-workspace"
-	} else {
-		failf("Invalid project file (%s), extension should be (.xcodeproj/.xcworkspace)", configs.ProjectPath)
-	}
+	useResultBundle := xcodebuildVersion.MajorVersion >= xcresultMinXcodeMajorVersion
 
-	log.Printf("- action: %s", action)
+	customOptions := []string{}
+	derivedDataPath := configs.DerivedDataPath
+	if configs.ArchivePath == "" {
+		// Custom xcodebuild options and build settings
+		options, err := parseXcodebuildOptions(configs.XcodebuildOptions, useResultBundle)
+		if err != nil {
+			failf("Invalid XcodebuildOptions (%s), error: %s", configs.XcodebuildOptions, err)
+		}
+
+		customBuildSettings, err := parseXcodebuildBuildSettings(configs.XcodebuildBuildSettings)
+		if err != nil {
+			failf("Invalid XcodebuildBuildSettings, error: %s", err)
+		}
+
+		customOptions = append(options, customBuildSettings...)
+		if err := validateForcedBuildSettings(configs, customOptions); err != nil {
+			failf("Invalid custom xcodebuild options, error: %s", err)
+		}
+
+		if customDerivedDataPath := customOptionValue(customOptions, "-derivedDataPath"); customDerivedDataPath != "" {
+			if configs.DerivedDataPath != "" {
+				failf("Both DerivedDataPath and -derivedDataPath in XcodebuildOptions are set, use the DerivedDataPath input")
+			}
+			if derivedDataPath, err = resolvePathInWorkDir(customDerivedDataPath, configs.WorkDir); err != nil {
+				failf("Failed to expand -derivedDataPath (%s), error: %s", customDerivedDataPath, err)
+			}
+		}
+		if configs.IsCacheDerivedData == "yes" {
+			if derivedDataPath == "" {
+				failf("IsCacheDerivedData is set, but DerivedDataPath is empty, the default DerivedData location is not cached")
+			}
+			if configs.IsCleanBuild == "yes" {
+				log.Warnf("IsCacheDerivedData is set, but IsCleanBuild removes the cached build products before the archive")
+			}
+		}
+
+		if configs.DependencyCheck != dependencyCheckNone {
+			fmt.Println()
+			log.Infof("Checking CocoaPods and Carthage dependencies ...")
+
+			drifts, err := checkDependencies(configs.ProjectPath)
+			if err != nil {
+				if configs.DependencyCheck == dependencyCheckFail {
+					failf("Failed to check dependencies, error: %s", err)
+				}
+				log.Warnf("Failed to check dependencies, error: %s", err)
+			} else if len(drifts) > 0 {
+				for _, drift := range drifts {
+					if configs.DependencyCheck == dependencyCheckFail {
+						log.Errorf("- %s", drift)
+					} else {
+						log.Warnf("- %s", drift)
+					}
+				}
+				if configs.DependencyCheck == dependencyCheckFail {
+					failf("The installed dependencies are out of sync with the lock files")
+				}
+			} else {
+				log.Donef("The installed dependencies match the lock files")
+			}
+			failIfDone(ctx, "Dependency check")
+		}
+
+		if configs.CodeSignCheck != codeSignCheckNone {
+			fmt.Println()
+			log.Infof("Checking code signing ...")
+			if err := checkCodeSigning(configs, customOptions); err != nil {
+				if err := codeSignCheckError(configs.CodeSignCheck, err); err != nil {
+					failf("Code signing check failed, error: %s", err)
+				}
+			} else {
+				log.Donef("The installed certificates and profiles can sign every target")
+			}
+			failIfDone(ctx, "Code signing check")
+		}
+
+		if configs.VersionUpdateMethod == versionUpdateMethodInfoPlist && (configs.BuildNumber != "" || configs.MarketingVersion != "") {
+			fmt.Println()
+			log.Infof("Updating the version of the scheme's Info.plist files ...")
+
+			infoPlistPths, err := schemeInfoPlistPaths(configs, customOptions)
+			if err != nil {
+				failf("Failed to find the Info.plist files of the scheme's targets, error: %s", err)
+			}
+			if err := updateInfoPlistVersions(infoPlistPths, configs.BuildNumber, configs.MarketingVersion); err != nil {
+				failf("Failed to update the version, error: %s", err)
+			}
+		}
+
+		// Project-or-Workspace flag
+		action := ""
+		if strings.HasSuffix(configs.ProjectPath, ".xcodeproj") {
+			action = "-project"
+		} else if strings.HasSuffix(configs.ProjectPath, ".xcworkspace") {
+			action = "-workspace"
+		} else {
+			failf("Invalid project file (%s), extension should be (.xcodeproj/.xcworkspace)", configs.ProjectPath)
+		}
+
+		log.Printf("- action: %s", action)
+		log.Printf("- workdir: %s", configs.WorkDir)
+		log.Printf("- projectPath: %s", configs.ProjectPath)
+	} else if configs.BuildNumber != "" || configs.MarketingVersion != "" {
+		log.Warnf("BuildNumber or MarketingVersion is set, but the version of an existing archive is not updated")
+	}
 
 	// export format
-	exportFormat := "app"
-	if configs.ExportMethod == "app-store" {
-		exportFormat = "pkg"
+	if configs.IsArchiveOnly == "yes" {
+		log.Printf("- archive only, the archive is not exported")
+	} else {
+		for _, method := range exportMethods(configs) {
+			log.Printf("- export_format: %s (%s)", exportFormatForMethod(method), method)
+		}
 	}
-	log.Printf("- export_format: %s", exportFormat)
 
 	fmt.Println()
 
@@ -290,38 +732,67 @@ func main() {
 	}
 
 	archivePath := filepath.Join(archiveTempDir, configs.ArtifactName+".xcarchive")
+	if configs.ArchivePath != "" {
+		if archivePath, err = openExistingArchive(configs.ArchivePath, archiveTempDir); err != nil {
+			failf("Failed to open the archive (%s), error: %s", configs.ArchivePath, err)
+		}
+	}
 	log.Printf("- archivePath: %s", archivePath)
 
 	archiveZipPath := filepath.Join(configs.OutputDir, configs.ArtifactName+".xcarchive.zip")
 	log.Printf("- archiveZipPath: %s", archiveZipPath)
 
-	exportOptionsPath := filepath.Join(configs.OutputDir, "export_options.plist")
-	log.Printf("- exportOptionsPath: %s", exportOptionsPath)
-
-	filePath := filepath.Join(configs.OutputDir, configs.ArtifactName+"."+exportFormat)
-	log.Printf("- filePath: %s", filePath)
+	exports := newMethodExports(configs, archiveTempDir)
+	if configs.ExportMethod != "none" {
+		for _, e := range exports {
+			log.Printf("- exportOptionsPath: %s", e.ExportOptionsPath)
+			log.Printf("- filePath: %s", e.FilePath)
+		}
+	}
 
 	dsymZipPath := filepath.Join(configs.OutputDir, configs.ArtifactName+".dSYM.zip")
 	log.Printf("- dsymZipPath: %s", dsymZipPath)
 
+	provenancePath := filepath.Join(configs.OutputDir, configs.ArtifactName+".provenance.json")
+	log.Printf("- provenancePath: %s", provenancePath)
+
+	archiveManifestPath := filepath.Join(configs.OutputDir, configs.ArtifactName+".xcarchive.json")
+	if configs.IsArchiveOnly == "yes" {
+		log.Printf("- archiveManifestPath: %s", archiveManifestPath)
+	}
+
 	rawXcodebuildOutputLogPath := filepath.Join(configs.OutputDir, "raw-xcodebuild-output.log")
 	log.Printf("- rawXcodebuildOutputLogPath: %s", rawXcodebuildOutputLogPath)
 
-	ideDistributionLogsZipPath := filepath.Join(configs.OutputDir, "xcodebuild.xcdistributionlogs.zip")
-	log.Printf("- ideDistributionLogsZipPath: %s", ideDistributionLogsZipPath)
+	xcresultPath := filepath.Join(archiveTempDir, configs.ArtifactName+".xcresult")
+	xcresultZipPath := filepath.Join(configs.OutputDir, configs.ArtifactName+".xcresult.zip")
+	if useResultBundle {
+		log.Printf("- xcresultZipPath: %s", xcresultZipPath)
+		for _, e := range exports {
+			log.Printf("- exportXcresultZipPath: %s", e.XcresultZipPath)
+		}
+	}
 
 	fmt.Println()
 
 	// clean-up
 	filesToCleanup := []string{
-		filePath,
+		filepath.Join(configs.OutputDir, configs.ArtifactName+".app"),
 		dsymZipPath,
+		provenancePath,
+		archiveManifestPath,
 		rawXcodebuildOutputLogPath,
 		archiveZipPath,
-		exportOptionsPath,
+		xcresultZipPath,
+	}
+	for _, e := range exports {
+		filesToCleanup = append(filesToCleanup, e.FilePath, e.ExportOptionsPath, e.XcresultZipPath)
 	}
 
 	for _, pth := range filesToCleanup {
+		if pth == configs.ArchivePath {
+			continue
+		}
 		if exist, err := pathutil.IsPathExists(pth); err != nil {
 			failf("Failed to check if path (%s) exist, error: %s", pth, err)
 		} else if exist {
@@ -331,83 +802,188 @@ func main() {
 		}
 	}
 
-	//
-	// Create the Archive with Xcode Command Line tools
-	log.Infof("Create archive ...")
-	fmt.Println()
+	retrier := newRetryPolicy(configs, derivedDataPath)
 
-	isWorkspace := false
-	ext := filepath.Ext(configs.ProjectPath)
-	if ext == ".xcodeproj" {
-		isWorkspace = false
-	} else if ext == ".xcworkspace" {
-		isWorkspace = true
-	} else {
-		failf("Project file extension should be .xcodeproj or .xcworkspace, but got: %s", ext)
-	}
+	exportedOutputs := exportedPathOutputs{}
+	stepCache := buildCache{}
+	if configs.ArchivePath == "" {
+		// Resolve Swift package dependencies
+		clonedSourcePackagesPath := ""
+		if configs.IsCacheSwiftPackages == "yes" {
+			fmt.Println()
+			log.Infof("Resolving Swift package dependencies ...")
 
-	archiveCmd := xcodebuild.NewArchiveCommand(configs.ProjectPath, isWorkspace)
-	archiveCmd.SetScheme(configs.Scheme)
-	archiveCmd.SetConfiguration(configs.Configuration)
+			if xcodebuildVersion.MajorVersion < 11 {
+				log.Warnf("Swift package dependencies can be resolved with Xcode 11 and above, skipping")
+			} else {
+				packagesPath := configs.ClonedSourcePackagesPath
+				customPackagesPath := customOptionValue(customOptions, "-clonedSourcePackagesDirPath")
+				if customPackagesPath != "" {
+					log.Warnf("-clonedSourcePackagesDirPath is set by XcodebuildOptions, using it instead of ClonedSourcePackagesPath")
+					if packagesPath, err = resolvePathInWorkDir(customPackagesPath, configs.WorkDir); err != nil {
+						failf("Failed to expand -clonedSourcePackagesDirPath (%s), error: %s", customPackagesPath, err)
+					}
+				}
 
-	if configs.ForceTeamID != "" {
-		log.Printf("Forcing Development Team: %s", configs.ForceTeamID)
-		archiveCmd.SetForceDevelopmentTeam(configs.ForceTeamID)
-	}
-	if configs.ForceProvisioningProfileSpecifier != "" {
-		log.Printf("Forcing Provisioning Profile Specifier: %s", configs.ForceProvisioningProfileSpecifier)
-		archiveCmd.SetForceProvisioningProfileSpecifier(configs.ForceProvisioningProfileSpecifier)
-	}
-	if configs.ForceProvisioningProfile != "" {
-		log.Printf("Forcing Provisioning Profile: %s", configs.ForceProvisioningProfile)
-		archiveCmd.SetForceProvisioningProfile(configs.ForceProvisioningProfile)
-	}
-	if configs.ForceCodeSignIdentity != "" {
-		log.Printf("Forcing Code Signing Identity: %s", configs.ForceCodeSignIdentity)
-		archiveCmd.SetForceCodeSignIdentity(configs.ForceCodeSignIdentity)
-	}
+				hasPackages, err := resolveSwiftPackages(ctx, configs, packagesPath, &stepCache)
+				failIfDone(ctx, "Resolving Swift package dependencies")
+				if err != nil {
+					failf("Failed to resolve Swift package dependencies, error: %s", err)
+				}
 
-	if configs.IsCleanBuild == "yes" {
-		archiveCmd.SetCustomBuildAction("clean")
-	}
+				if !hasPackages {
+					log.Printf("No Package.resolved found, the project does not use Swift packages")
+				} else {
+					log.Donef("Swift packages resolved into: %s", packagesPath)
+					if customPackagesPath == "" {
+						clonedSourcePackagesPath = packagesPath
+					}
+				}
+			}
+			fmt.Println()
+		}
 
-	archiveCmd.SetArchivePath(archivePath)
+		//
+		// Create the Archive with Xcode Command Line tools
+		log.Infof("Create archive ...")
+		fmt.Println()
 
-	if configs.OutputTool == "xcpretty" {
-		xcprettyCmd := xcpretty.New(archiveCmd)
+		isWorkspace := false
+		ext := filepath.Ext(configs.ProjectPath)
+		if ext == ".xcodeproj" {
+			isWorkspace = false
+		} else if ext == ".xcworkspace" {
+			isWorkspace = true
+		} else {
+			failf("Project file extension should be .xcodeproj or .xcworkspace, but got: %s", ext)
+		}
 
-		log.TSuccessf("$ %s", xcprettyCmd.PrintableCmd())
-		fmt.Println()
+		archiveCmd := xcodebuild.NewArchiveCommand(configs.ProjectPath, isWorkspace)
+		archiveCmd.SetDir(configs.WorkDir)
+		archiveCmd.SetScheme(configs.Scheme)
+		archiveCmd.SetConfiguration(configs.Configuration)
 
-		if rawXcodebuildOut, err := xcprettyCmd.Run(); err != nil {
+		if configs.ForceTeamID != "" {
+			log.Printf("Forcing Development Team: %s", configs.ForceTeamID)
+			archiveCmd.SetForceDevelopmentTeam(configs.ForceTeamID)
+		}
+		if configs.ForceProvisioningProfileSpecifier != "" {
+			log.Printf("Forcing Provisioning Profile Specifier: %s", configs.ForceProvisioningProfileSpecifier)
+			archiveCmd.SetForceProvisioningProfileSpecifier(configs.ForceProvisioningProfileSpecifier)
+		}
+		if configs.ForceProvisioningProfile != "" {
+			log.Printf("Forcing Provisioning Profile: %s", configs.ForceProvisioningProfile)
+			archiveCmd.SetForceProvisioningProfile(configs.ForceProvisioningProfile)
+		}
+		if configs.ForceCodeSignIdentity != "" {
+			log.Printf("Forcing Code Signing Identity: %s", configs.ForceCodeSignIdentity)
+			archiveCmd.SetForceCodeSignIdentity(configs.ForceCodeSignIdentity)
+		}
 
-			log.Errorf("\nLast lines of the Xcode's build log:")
-			fmt.Println(stringutil.LastNLines(rawXcodebuildOut, 10))
+		if configs.VersionUpdateMethod == versionUpdateMethodBuildSettings {
+			if configs.BuildNumber != "" {
+				log.Printf("Setting build number (CURRENT_PROJECT_VERSION): %s", configs.BuildNumber)
+				archiveCmd.SetCurrentProjectVersion(configs.BuildNumber)
+			}
+			if configs.MarketingVersion != "" {
+				log.Printf("Setting version (MARKETING_VERSION): %s", configs.MarketingVersion)
+				archiveCmd.SetMarketingVersion(configs.MarketingVersion)
+			}
+		}
 
-			if err := output.ExportOutputFileContent(rawXcodebuildOut, rawXcodebuildOutputLogPath, bitriseXcodeRawResultTextEnvKey); err != nil {
-				log.Warnf("Failed to export %s, error: %s", bitriseXcodeRawResultTextEnvKey, err)
-			} else {
-				log.Warnf(`You can find the last couple of lines of Xcode's build log above, but the full log is also available in the raw-xcodebuild-output.log
+		if configs.IsCleanBuild == "yes" {
+			archiveCmd.SetCustomBuildAction("clean")
+		}
+
+		archiveCmd.SetArchivePath(archivePath)
+
+		if useResultBundle {
+			archiveCmd.SetResultBundlePath(xcresultPath)
+		}
+
+		if configs.DerivedDataPath != "" {
+			archiveCmd.SetDerivedDataPath(configs.DerivedDataPath)
+		}
+
+		if clonedSourcePackagesPath != "" {
+			archiveCmd.SetClonedSourcePackagesDirPath(clonedSourcePackagesPath)
+		}
+
+		if len(customOptions) > 0 {
+			log.Printf("Custom xcodebuild options: %s", command.PrintableCommandArgs(false, customOptions))
+			archiveCmd.SetCustomOptions(customOptions)
+		}
+
+		archivePhase := newStepPhase(ctx, "archive", parseTimeout(configs.ArchiveTimeoutSeconds))
+		rawXcodebuildOut, attempts, err := retrier.run("archive", func(attempt uint) (string, error) {
+			if attempt > 0 {
+				// xcodebuild does not overwrite the result bundle of the previous attempt
+				if err := os.RemoveAll(xcresultPath); err != nil {
+					return "", fmt.Errorf("failed to remove the result bundle of the previous attempt, error: %s", err)
+				}
+			}
+
+			if configs.OutputTool == "xcpretty" {
+				xcprettyCmd := xcpretty.New(archiveCmd)
+
+				log.TSuccessf("$ %s", xcprettyCmd.PrintableCmd())
+				fmt.Println()
+
+				return xcprettyCmd.RunWithContext(archivePhase.ctx)
+			}
+
+			log.TSuccessf("$ %s", archiveCmd.PrintableCmd())
+			fmt.Println()
+
+			return archiveCmd.RunAndReturnOutputWithContext(archivePhase.ctx)
+		})
+		terminated, timedOut := archivePhase.isDone(), archivePhase.isTimedOut()
+		err = archivePhase.err(err)
+		archivePhase.cancel()
+
+		printAttemptSummary("archive", attempts)
+		if err != nil {
+			if configs.OutputTool == "xcpretty" {
+				log.Errorf("\nLast lines of the Xcode's build log:")
+				fmt.Println(stringutil.LastNLines(rawXcodebuildOut, 10))
+			}
+
+			// the partial log of a terminated archive is exported, even if it was printed
+			if configs.OutputTool == "xcpretty" || terminated {
+				if err := output.ExportOutputFileContent(rawXcodebuildOut, rawXcodebuildOutputLogPath, bitriseXcodeRawResultTextEnvKey); err != nil {
+					log.Warnf("Failed to export %s, error: %s", bitriseXcodeRawResultTextEnvKey, err)
+				} else {
+					log.Warnf(`You can find the last couple of lines of Xcode's build log above, but the full log is also available in the raw-xcodebuild-output.log
 The log file is stored in $BITRISE_DEPLOY_DIR, and its full path is available in the $BITRISE_XCODE_RAW_RESULT_TEXT_PATH environment variable
 (value: %s)`, rawXcodebuildOutputLogPath)
+				}
 			}
 
-			failf("Archive failed, error: %s", err)
+			exportXcresult(xcresultPath, xcresultZipPath, bitriseXcresultZipPthEnvKey)
+			if timedOut {
+				timedOutf("Archive timed out, error: %s", err)
+			}
+			failf("Archive failed, error: %s%s", err, xcresultFailureReason(xcresultPath))
 		}
-	} else {
-		log.TSuccessf("$ %s", archiveCmd.PrintableCmd())
-		fmt.Println()
 
-		if err := archiveCmd.Run(); err != nil {
-			failf("Archive failed, error: %s", err)
+		if pth := exportXcresult(xcresultPath, xcresultZipPath, bitriseXcresultZipPthEnvKey); pth != "" {
+			exportedOutputs[bitriseXcresultZipPthEnvKey] = pth
 		}
-	}
 
-	// Ensure xcarchive exists
-	if exist, err := pathutil.IsPathExists(archivePath); err != nil {
-		failf("Failed to check if archive exist, error: %s", err)
-	} else if !exist {
-		failf("No archive generated at: %s", archivePath)
+		// Ensure xcarchive exists
+		if exist, err := pathutil.IsPathExists(archivePath); err != nil {
+			failf("Failed to check if archive exist, error: %s", err)
+		} else if !exist {
+			failf("No archive generated at: %s", archivePath)
+		}
+
+		if configs.IsCacheDerivedData == "yes" {
+			if err := cacheDerivedData(derivedDataPath, &stepCache); err != nil {
+				log.Warnf("Failed to cache DerivedData, error: %s", err)
+			} else {
+				log.Donef("DerivedData (%s) added to the build cache", derivedDataPath)
+			}
+		}
 	}
 
 	archive, err := xcarchive.NewMacosArchive(archivePath)
@@ -415,10 +991,26 @@ The log file is stored in $BITRISE_DEPLOY_DIR, and its full path is available in
 		failf("Failed to parse archive, error: %s", err)
 	}
 
-	identity := archive.SigningIdentity()
+	if configs.ArchivePath == "" && (configs.BuildNumber != "" || configs.MarketingVersion != "") {
+		if err := verifyArchivedVersions(archive, configs.BuildNumber, configs.MarketingVersion); err != nil {
+			hint := ""
+			if configs.VersionUpdateMethod == versionUpdateMethodBuildSettings {
+				hint = "\nMake sure the Info.plist refers the $(CURRENT_PROJECT_VERSION) and $(MARKETING_VERSION) build settings, or use the info_plist version update method."
+			}
+			failf("The archived app does not carry the requested version, error: %s%s", err, hint)
+		}
+		log.Donef("The archived app carries the requested version")
+	}
 
 	log.Infof("Archive infos:")
-	log.Printf("codesign identity: %v", identity)
+	exportMethod := usedExportMethod(configs)
+	if configs.IsArchiveOnly == "yes" {
+		exportMethod = ""
+	}
+	metadata := newArchiveMetadata(archive, exportMethod)
+	if err := metadata.export(); err != nil {
+		failf("Failed to export the archive's metadata, error: %s", err)
+	}
 	fmt.Println()
 
 	// Exporting xcarchive
@@ -429,6 +1021,7 @@ The log file is stored in $BITRISE_DEPLOY_DIR, and its full path is available in
 	if err := output.ExportOutputDir(archivePath, archivePath, bitriseXCArchiveDirPthEnvKey); err != nil {
 		failf("Failed to export %s, error: %s", bitriseXCArchiveDirPthEnvKey, err)
 	}
+	exportedOutputs[bitriseXCArchiveDirPthEnvKey] = archivePath
 
 	log.Donef("The xcarchive path is now available in the Environment Variable: %s (value: %s)", bitriseXCArchiveDirPthEnvKey, archivePath)
 
@@ -436,12 +1029,30 @@ The log file is stored in $BITRISE_DEPLOY_DIR, and its full path is available in
 		if err := output.ZipAndExportOutput(archivePath, archiveZipPath, bitriseXCArchivePthEnvKey); err != nil {
 			failf("Failed to export %s, error: %s", bitriseXCArchivePthEnvKey, err)
 		}
+		exportedOutputs[bitriseXCArchivePthEnvKey] = archiveZipPath
 
 		log.Donef("The xcarchive zip path is now available in the Environment Variable: %s (value: %s)", bitriseXCArchivePthEnvKey, archiveZipPath)
+		failIfDone(ctx, "Zipping the xcarchive")
 	}
 
 	fmt.Println()
 
+	// Archive only: the archive and its manifest are the step's outputs, the app is exported by the next steps
+	if configs.IsArchiveOnly == "yes" {
+		if err := exportArchiveManifest(configs, xcodebuildVersion, metadata, exportedOutputs, archiveManifestPath); err != nil {
+			failf("Failed to export the archive manifest, error: %s", err)
+		}
+		log.Donef("The archive manifest path is now available in the Environment Variable: %s (value: %s)", bitriseXCArchiveManifestPthEnvKey, archiveManifestPath)
+
+		if err := exportedOutputs.verify(); err != nil {
+			failf("Output check failed, error: %s", err)
+		}
+		if err := writeArchiveJobOutput(exportedOutputs, metadata, stepCache); err != nil {
+			failf("Failed to write the archive job's outputs, error: %s", err)
+		}
+		return
+	}
+
 	// Export APP from generated archive
 	log.Infof("Exporting APP from generated Archive ...")
 
@@ -472,238 +1083,96 @@ The log file is stored in $BITRISE_DEPLOY_DIR, and its full path is available in
 		embeddedAppPath := matches[0]
 		appPath := filepath.Join(configs.OutputDir, configs.ArtifactName+".app")
 
-		if err := output.ExportOutputDir(embeddedAppPath, appPath, bitriseAppPthEnvKey); err != nil {
-			failf("Failed to export %s, error: %s", bitriseAppPthEnvKey, err)
+		if err := exportApp(embeddedAppPath, appPath, bitriseAppPthEnvKey, bitriseExportedFilePath, exportedOutputs); err != nil {
+			failf("Failed to export the app, error: %s", err)
 		}
-
-		log.Donef("The app path is now available in the Environment Variable: %s (value: %s)", bitriseAppPthEnvKey, appPath)
-
-		filePath = filePath + ".zip"
-		if err := output.ZipAndExportOutput(embeddedAppPath, filePath, bitriseExportedFilePath); err != nil {
-			failf("Failed to export %s, error: %s", bitriseExportedFilePath, err)
-		}
-
-		log.Donef("The app.zip path is now available in the Environment Variable: %s (value: %s)", bitriseExportedFilePath, filePath)
 	} else {
 		// export using exportOptions
 		log.Printf("Export using exportOptions...")
 		fmt.Println()
 
-		exportTmpDir, err := pathutil.NormalizedOSTempDirPath("__export__")
+		exportPhase := newStepPhase(ctx, "export", parseTimeout(configs.ExportTimeoutSeconds))
+		outputs, err := exportArchive(configs, archive, exports, useResultBundle, retrier, exportPhase)
+		timedOut := exportPhase.isTimedOut()
+		exportPhase.cancel()
 		if err != nil {
-			failf("Failed to create export tmp dir, error: %s", err)
-		}
-
-		exportCmd := xcodebuild.NewExportCommand()
-		exportCmd.SetArchivePath(archivePath)
-		exportCmd.SetExportDir(exportTmpDir)
-
-		if configs.CustomExportOptionsPlistContent != "" {
-			log.Printf("Custom export options content provided:")
-			fmt.Println(configs.CustomExportOptionsPlistContent)
-
-			if err := fileutil.WriteStringToFile(exportOptionsPath, configs.CustomExportOptionsPlistContent); err != nil {
-				failf("Failed to write export options to file, error: %s", err)
-			}
-		} else {
-			exportMethod, err := exportoptions.ParseMethod(configs.ExportMethod)
-			if err != nil {
-				failf("Failed to parse export method, error: %s", err)
-			}
-
-			bundleIDEntitlemnstMap := archive.BundleIDEntitlementsMap()
-			bundleIDs := []string{}
-			for bundleID := range bundleIDEntitlemnstMap {
-				bundleIDs = append(bundleIDs, bundleID)
-			}
-
-			installedCertificates, err := certificateutil.InstalledCodesigningCertificateInfos()
-			if err != nil {
-				failf("Failed to get installed certificates, error: %s", err)
-			}
-			installedCertificates = certificateutil.FilterValidCertificateInfos(installedCertificates)
-
-			log.Debugf("\n")
-			log.Debugf("Installed certificates:")
-			for _, certInfo := range installedCertificates {
-				log.Debugf(certInfo.String())
-			}
-
-			installedProfiles, err := profileutil.InstalledProvisioningProfileInfos(profileutil.ProfileTypeMacOs)
-			if err != nil {
-				failf("Failed to get installed provisioning profiles, error: %s", err)
-			}
-
-			log.Debugf("\n")
-			log.Debugf("Installed profiles:")
-			for _, profInfo := range installedProfiles {
-				log.Debugf(profInfo.String())
-			}
-
-			codesignGroups := export.CreateSelectableCodeSignGroups(installedCertificates, installedProfiles, bundleIDs)
-			if len(codesignGroups) == 0 {
-				log.Errorf("Failed to find code singing groups for the project")
-			}
-
-			codesignGroups = export.FilterSelectableCodeSignGroups(codesignGroups,
-				export.CreateEntitlementsSelectableCodeSignGroupFilter(bundleIDEntitlemnstMap),
-				export.CreateExportMethodSelectableCodeSignGroupFilter(exportMethod),
-			)
-
-			installedInstallerCertificates := []certificateutil.CertificateInfoModel{}
-
-			if exportMethod == exportoptions.MethodAppStore {
-				installedInstallerCertificates, err = certificateutil.InstalledInstallerCertificateInfos()
-				if err != nil {
-					log.Errorf("Failed to read installed Installer certificates, error: %s", err)
-				}
-
-				installedInstallerCertificates = certificateutil.FilterValidCertificateInfos(installedInstallerCertificates)
-
-				log.Debugf("\n")
-				log.Debugf("Installed installer certificates:")
-				for _, certInfo := range installedInstallerCertificates {
-					log.Debugf(certInfo.String())
-				}
-			}
-
-			var macCodeSignGroup *export.MacCodeSignGroup
-			macCodeSignGroups := export.CreateMacCodeSignGroup(codesignGroups, installedInstallerCertificates, exportMethod)
-			if len(macCodeSignGroups) == 0 {
-				log.Errorf("Can not create macos codesiging groups for the project")
-			} else if len(macCodeSignGroups) > 1 {
-				log.Warnf("Multiple matching  codesiging groups found for the project, using first...")
-				macCodeSignGroup = &(macCodeSignGroups[0])
-			} else {
-				macCodeSignGroup = &(macCodeSignGroups[0])
-			}
-
-			exportProfileMapping := map[string]string{}
-			if macCodeSignGroup != nil {
-				for bundleID, profileInfo := range macCodeSignGroup.BundleIDProfileMap {
-					exportProfileMapping[bundleID] = profileInfo.Name
-				}
-			}
-
-			var exportOpts exportoptions.ExportOptions
-			if exportMethod == exportoptions.MethodAppStore {
-				options := exportoptions.NewAppStoreOptions()
-
-				if macCodeSignGroup != nil {
-					options.BundleIDProvisioningProfileMapping = exportProfileMapping
-					options.SigningCertificate = macCodeSignGroup.Certificate.CommonName
-					options.InstallerSigningCertificate = macCodeSignGroup.InstallerCertificate.CommonName
-				}
-
-				exportOpts = options
-			} else {
-				options := exportoptions.NewNonAppStoreOptions(exportMethod)
-
-				if macCodeSignGroup != nil {
-					options.BundleIDProvisioningProfileMapping = exportProfileMapping
-					options.SigningCertificate = macCodeSignGroup.Certificate.CommonName
-				}
-
-				exportOpts = options
-			}
-
-			log.Printf("generated export options content:")
-			fmt.Println()
-			fmt.Println(exportOpts.String())
-
-			if err = exportOpts.WriteToFile(exportOptionsPath); err != nil {
-				failf("Failed to write export options to file, error: %s", err)
+			if timedOut {
+				timedOutf("Export timed out, error: %s", err)
 			}
+			failf("Export failed, error: %s", err)
+		}
+		for key, pth := range outputs {
+			exportedOutputs[key] = pth
 		}
 
-		exportCmd.SetExportOptionsPlist(exportOptionsPath)
-
-		if configs.OutputTool == "xcpretty" {
-			xcprettyCmd := xcpretty.New(exportCmd)
-
-			log.Donef("$ %s", xcprettyCmd.PrintableCmd())
+		// the outputs of the first export method are available with the step's original output keys as well
+		if len(exports) > 1 {
 			fmt.Println()
-
-			if xcodebuildOut, err := xcprettyCmd.Run(); err != nil {
-				// xcodebuild raw output
-				if err := output.ExportOutputFileContent(xcodebuildOut, rawXcodebuildOutputLogPath, bitriseXcodeRawResultTextEnvKey); err != nil {
-					log.Warnf("Failed to export %s, error: %s", bitriseXcodeRawResultTextEnvKey, err)
-				} else {
-					log.Warnf(`If you can't find the reason of the error in the log, please check the raw-xcodebuild-output.log
-The log file is stored in $BITRISE_DEPLOY_DIR, and its full path
-is available in the $BITRISE_XCODE_RAW_RESULT_TEXT_PATH environment variable (value: %s)`, rawXcodebuildOutputLogPath)
+			first := exports[0]
+			defaultKeys := map[string]string{
+				first.AppEnvKey:          bitriseAppPthEnvKey,
+				first.ExportedFileEnvKey: bitriseExportedFilePath,
+				first.XcresultZipEnvKey:  bitriseExportXcresultZipPthEnvKey,
+			}
+			for _, key := range outputs.keys() {
+				defaultKey, ok := defaultKeys[key]
+				if !ok {
+					continue
 				}
-
-				// xcdistributionlogs
-				if logsDirPth, err := findIDEDistrubutionLogsPath(xcodebuildOut); err != nil {
-					log.Warnf("Failed to find xcdistributionlogs, error: %s", err)
-				} else if err := output.ZipAndExportOutput(logsDirPth, ideDistributionLogsZipPath, bitriseIDEDistributionLogsPthEnvKey); err != nil {
-					log.Warnf("Failed to export %s, error: %s", bitriseIDEDistributionLogsPthEnvKey, err)
-				} else {
-					criticalDistLogFilePth := filepath.Join(logsDirPth, "IDEDistribution.critical.log")
-					log.Warnf("IDEDistribution.critical.log:")
-					if criticalDistLog, err := fileutil.ReadStringFromFile(criticalDistLogFilePth); err == nil {
-						log.Printf(criticalDistLog)
-					}
-
-					log.Warnf(`If you can't find the reason of the error in the log, please check the xcdistributionlogs
-The logs directory is stored in $BITRISE_DEPLOY_DIR, and its full path
-is available in the $BITRISE_IDEDISTRIBUTION_LOGS_PATH environment variable (value: %s)`, ideDistributionLogsZipPath)
+				if err := tools.ExportEnvironmentWithEnvman(defaultKey, outputs[key]); err != nil {
+					failf("Failed to export %s, error: %s", defaultKey, err)
 				}
-
-				failf("Export failed, error: %s", err)
+				exportedOutputs[defaultKey] = outputs[key]
+				log.Donef("The %s export's %s is now available in the Environment Variable: %s", first.Method, key, defaultKey)
 			}
-		} else {
-			log.Donef("$ %s", exportCmd.PrintableCmd())
-			fmt.Println()
-
-			if xcodebuildOut, err := exportCmd.RunAndReturnOutput(); err != nil {
-				// xcdistributionlogs
-				if logsDirPth, err := findIDEDistrubutionLogsPath(xcodebuildOut); err != nil {
-					log.Warnf("Failed to find xcdistributionlogs, error: %s", err)
-				} else if err := output.ZipAndExportOutput(logsDirPth, ideDistributionLogsZipPath, bitriseIDEDistributionLogsPthEnvKey); err != nil {
-					log.Warnf("Failed to export %s, error: %s", bitriseIDEDistributionLogsPthEnvKey, err)
-				} else {
-					criticalDistLogFilePth := filepath.Join(logsDirPth, "IDEDistribution.critical.log")
-					log.Warnf("IDEDistribution.critical.log:")
-					if criticalDistLog, err := fileutil.ReadStringFromFile(criticalDistLogFilePth); err == nil {
-						log.Printf(criticalDistLog)
-					}
+		}
+	}
 
-					log.Warnf(`If you can't find the reason of the error in the log, please check the xcdistributionlogs
-The logs directory is stored in $BITRISE_DEPLOY_DIR, and its full path
-is available in the $BITRISE_IDEDISTRIBUTION_LOGS_PATH environment variable (value: %s)`, ideDistributionLogsZipPath)
-				}
+	// Checksums and provenance
+	fmt.Println()
+	log.Infof("Generating the artifacts' provenance ...")
 
-				failf("Export failed, error: %s", err)
-			}
+	artifactPths := []string{}
+	artifactKeys := []string{bitriseExportedFilePath, bitriseXCArchivePthEnvKey}
+	for _, e := range exports {
+		artifactKeys = append(artifactKeys, e.ExportedFileEnvKey)
+	}
+	for _, key := range artifactKeys {
+		if pth, ok := exportedOutputs[key]; ok && !sliceutil.IsStringInSlice(pth, artifactPths) {
+			artifactPths = append(artifactPths, pth)
 		}
+	}
+	if exist, err := pathutil.IsPathExists(dsymZipPath); err != nil {
+		failf("Failed to check if path (%s) exist, error: %s", dsymZipPath, err)
+	} else if exist {
+		artifactPths = append(artifactPths, dsymZipPath)
+	}
 
-		// find exported app
-		pattern := filepath.Join(exportTmpDir, "*."+exportFormat)
-		apps, err := filepath.Glob(pattern)
-		if err != nil {
-			failf("Failed to find app, with pattern: %s, error: %s", pattern, err)
-		}
+	provenanceAppPath := archive.Application.Path
+	if pth, ok := exportedOutputs[bitriseAppPthEnvKey]; ok {
+		provenanceAppPath = pth
+	}
 
-		if len(apps) > 0 {
-			if exportFormat == "pkg" {
-				if err := output.ExportOutputFile(apps[0], filePath, bitriseExportedFilePath); err != nil {
-					failf("Failed to export %s, error: %s", bitriseExportedFilePath, err)
-				}
-			} else {
-				if err := tools.ExportEnvironmentWithEnvman(bitriseAppPthEnvKey, filePath); err != nil {
-					failf("Failed to export %s, error: %s", bitriseAppPthEnvKey, err)
-				}
-				filePath = filePath + ".zip"
-				if err := output.ZipAndExportOutput(apps[0], filePath, bitriseExportedFilePath); err != nil {
-					failf("Failed to export %s, error: %s", bitriseExportedFilePath, err)
-				}
-			}
+	provenance, err := newProvenanceStatement(artifactPths, provenanceAppPath, configs, xcodebuildVersion, metadata)
+	if err != nil {
+		failf("Failed to generate provenance, error: %s", err)
+	}
+	if err := writeProvenance(provenance, provenancePath); err != nil {
+		failf("Failed to write provenance, error: %s", err)
+	}
+	if err := output.ExportOutputFile(provenancePath, provenancePath, bitriseProvenancePathEnvKey); err != nil {
+		failf("Failed to export %s, error: %s", bitriseProvenancePathEnvKey, err)
+	}
+	exportedOutputs[bitriseProvenancePathEnvKey] = provenancePath
 
-			fmt.Println()
-			log.Donef("The app path is now available in the Environment Variable: %s (value: %s)", bitriseExportedFilePath, filePath)
-		}
+	log.Donef("The provenance path is now available in the Environment Variable: %s (value: %s)", bitriseProvenancePathEnvKey, provenancePath)
+	failIfDone(ctx, "Generating the provenance")
+
+	if err := exportedOutputs.verify(); err != nil {
+		failf("Output check failed, error: %s", err)
 	}
 
+	if err := writeArchiveJobOutput(exportedOutputs, metadata, stepCache); err != nil {
+		failf("Failed to write the archive job's outputs, error: %s", err)
+	}
 }
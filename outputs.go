@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-tools/go-steputils/output"
+)
+
+// exportedPathOutputs maps the exported path outputs' environment variable keys to their values.
+type exportedPathOutputs map[string]string
+
+// keys returns the environment variable keys in alphabetical order.
+func (outputs exportedPathOutputs) keys() []string {
+	keys := []string{}
+	for key := range outputs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// verify returns error if any of the exported paths does not exist.
+func (outputs exportedPathOutputs) verify() error {
+	missing := []string{}
+	for _, key := range outputs.keys() {
+		pth := outputs[key]
+		if exist, err := pathutil.IsPathExists(pth); err != nil {
+			return fmt.Errorf("failed to check if %s (%s) exists, error: %s", key, pth, err)
+		} else if !exist {
+			missing = append(missing, fmt.Sprintf("%s (%s)", key, pth))
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("exported path(s) do not exist: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// exportApp copies the app to the given path (preserving the symlinks and the code signature) and zips the copy,
+// so the exported app and the zip have the same content.
+func exportApp(sourceAppPth, appPth, appEnvKey, zipEnvKey string, outputs exportedPathOutputs) error {
+	if err := output.ExportOutputDir(sourceAppPth, appPth, appEnvKey); err != nil {
+		return fmt.Errorf("failed to export %s, error: %s", appEnvKey, err)
+	}
+	outputs[appEnvKey] = appPth
+
+	fmt.Println()
+	log.Donef("The app path is now available in the Environment Variable: %s (value: %s)", appEnvKey, appPth)
+
+	zipPth := appPth + ".zip"
+	if err := output.ZipAndExportOutput(appPth, zipPth, zipEnvKey); err != nil {
+		return fmt.Errorf("failed to export %s, error: %s", zipEnvKey, err)
+	}
+	outputs[zipEnvKey] = zipPth
+
+	log.Donef("The app.zip path is now available in the Environment Variable: %s (value: %s)", zipEnvKey, zipPth)
+	return nil
+}
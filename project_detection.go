@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-io/go-utils/sliceutil"
+	"github.com/bitrise-tools/go-xcode/xcodeproj"
+)
+
+// skippedSearchDirs are not searched for projects and workspaces.
+var skippedSearchDirs = []string{
+	".git",
+	"Pods",
+	"Carthage",
+	"node_modules",
+	"DerivedData",
+}
+
+// findProjectsAndWorkspaces returns the .xcodeproj and .xcworkspace paths found under the given dir,
+// skipping the dependency managers' directories and the workspaces embedded into projects.
+func findProjectsAndWorkspaces(searchDir string) ([]string, []string, error) {
+	projects := []string{}
+	workspaces := []string{}
+
+	if err := filepath.Walk(searchDir, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+
+		name := info.Name()
+		for _, skipped := range skippedSearchDirs {
+			if name == skipped {
+				return filepath.SkipDir
+			}
+		}
+
+		if xcodeproj.IsXCodeProj(name) {
+			projects = append(projects, pth)
+			return filepath.SkipDir
+		}
+		if xcodeproj.IsXCWorkspace(name) {
+			workspaces = append(workspaces, pth)
+			return filepath.SkipDir
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	sort.Strings(projects)
+	sort.Strings(workspaces)
+
+	return projects, workspaces, nil
+}
+
+// detectProjectPath returns the only workspace found under the search dir,
+// or if there is no workspace, the only project.
+func detectProjectPath(searchDir string) (string, error) {
+	projects, workspaces, err := findProjectsAndWorkspaces(searchDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to search for projects in: %s, error: %s", searchDir, err)
+	}
+
+	candidates := workspaces
+	if len(candidates) == 0 {
+		candidates = projects
+	}
+
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("no .xcworkspace or .xcodeproj found in: %s", searchDir)
+	} else if len(candidates) > 1 {
+		return "", fmt.Errorf("multiple candidates found in: %s, set the project_path input to one of them:\n- %s", searchDir, strings.Join(candidates, "\n- "))
+	}
+
+	return candidates[0], nil
+}
+
+// schemeBuildsMacOSApplication returns true if the scheme's archivable application target is built with the macOS SDK,
+// the target's build settings are resolved for the scheme's archive configuration.
+func schemeBuildsMacOSApplication(scheme xcodeproj.Scheme, schemeContainerPth string, resolvers map[string]*xcodeproj.BuildSettingsResolver) (bool, error) {
+	reference, found := scheme.AppBuildableReference()
+	if !found || reference.BlueprintName == "" || reference.ReferencedContainer == "" {
+		return false, nil
+	}
+
+	projectPth := reference.ReferencedContainerAbsPath(filepath.Dir(schemeContainerPth))
+	resolver, ok := resolvers[projectPth]
+	if !ok {
+		if exist, err := pathutil.IsPathExists(projectPth); err != nil {
+			return false, err
+		} else if !exist {
+			log.Warnf("Project (%s) referred by scheme (%s) not found", projectPth, scheme.Name)
+			return false, nil
+		}
+
+		var err error
+		resolver, err = xcodeproj.NewBuildSettingsResolver(projectPth)
+		if err != nil {
+			return false, fmt.Errorf("failed to parse project: %s, error: %s", projectPth, err)
+		}
+		resolvers[projectPth] = resolver
+	}
+
+	buildSettings, err := resolver.TargetBuildSettings(reference.BlueprintName, scheme.ArchiveAction.BuildConfiguration)
+	if err != nil {
+		return false, fmt.Errorf("failed to resolve the build settings of scheme (%s), error: %s", scheme.Name, err)
+	}
+	return buildSettings.Value("PLATFORM_NAME") == "macosx", nil
+}
+
+// detectScheme returns the only shared scheme of the project or workspace, which builds a macOS application.
+func detectScheme(projectOrWorkspacePth string) (string, error) {
+	var sharedSchemes []xcodeproj.SchemeModel
+	var err error
+	if xcodeproj.IsXCWorkspace(projectOrWorkspacePth) {
+		sharedSchemes, err = xcodeproj.WorkspaceSharedSchemes(projectOrWorkspacePth)
+	} else {
+		sharedSchemes, err = xcodeproj.ProjectSharedSchemes(projectOrWorkspacePth)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to list shared schemes of: %s, error: %s", projectOrWorkspacePth, err)
+	}
+
+	if len(sharedSchemes) == 0 {
+		return "", fmt.Errorf("no shared scheme found in: %s, mark your scheme as shared in Xcode or set the scheme input", projectOrWorkspacePth)
+	}
+
+	resolvers := map[string]*xcodeproj.BuildSettingsResolver{}
+	candidateMap := map[string]bool{}
+	schemeNames := []string{}
+	for _, sharedScheme := range sharedSchemes {
+		if sliceutil.IsStringInSlice(sharedScheme.Name, schemeNames) {
+			continue
+		}
+		schemeNames = append(schemeNames, sharedScheme.Name)
+
+		scheme, schemeContainerPth, err := xcodeproj.FindScheme(projectOrWorkspacePth, sharedScheme.Name, "")
+		if err != nil {
+			return "", err
+		}
+
+		isMacOSApplication, err := schemeBuildsMacOSApplication(scheme, schemeContainerPth, resolvers)
+		if err != nil {
+			return "", err
+		}
+		if isMacOSApplication {
+			candidateMap[scheme.Name] = true
+		}
+	}
+
+	candidates := []string{}
+	for scheme := range candidateMap {
+		candidates = append(candidates, scheme)
+	}
+	sort.Strings(candidates)
+
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("none of the shared schemes builds a macOS application, set the scheme input to one of them:\n- %s", strings.Join(schemeNames, "\n- "))
+	}
+
+	return "", fmt.Errorf("multiple shared schemes build a macOS application, set the scheme input to one of them:\n- %s", strings.Join(candidates, "\n- "))
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testTarget is an application target of a test project, an empty sdk inherits the project level SDKROOT.
+type testTarget struct {
+	name string
+	sdk  string
+}
+
+func createTestFile(t *testing.T, pth, content string) {
+	if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+		t.Fatalf("failed to create dir: %s", err)
+	}
+	if err := ioutil.WriteFile(pth, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+}
+
+func createTestProject(t *testing.T, projectPth, projectSDK string, targets ...testTarget) {
+	objects := []string{
+		`P0 = {isa = PBXProject; buildConfigurationList = PL; mainGroup = G0; projectDirPath = ""; targets = (` + testTargetIDs(targets) + `); };`,
+		`G0 = {isa = PBXGroup; children = (); sourceTree = "<group>"; };`,
+		`PL = {isa = XCConfigurationList; buildConfigurations = (PC); defaultConfigurationName = Release; };`,
+		fmt.Sprintf(`PC = {isa = XCBuildConfiguration; name = Release; buildSettings = {SDKROOT = "%s"; }; };`, projectSDK),
+	}
+	for i, target := range targets {
+		settings := ""
+		if target.sdk != "" {
+			settings = fmt.Sprintf(`SDKROOT = "%s"; `, target.sdk)
+		}
+		objects = append(objects,
+			fmt.Sprintf(`T%d = {isa = PBXNativeTarget; name = "%s"; productName = "%s"; productType = "com.apple.product-type.application"; buildConfigurationList = TL%d; };`, i, target.name, target.name, i),
+			fmt.Sprintf(`TL%d = {isa = XCConfigurationList; buildConfigurations = (TC%d); defaultConfigurationName = Release; };`, i, i),
+			fmt.Sprintf(`TC%d = {isa = XCBuildConfiguration; name = Release; buildSettings = {%s}; };`, i, settings),
+		)
+	}
+
+	content := "// !$*UTF8*$!\n{\n\tarchiveVersion = 1;\n\tobjectVersion = 50;\n\tobjects = {\n\t\t" +
+		strings.Join(objects, "\n\t\t") +
+		"\n\t};\n\trootObject = P0;\n}\n"
+	createTestFile(t, filepath.Join(projectPth, "project.pbxproj"), content)
+}
+
+func testTargetIDs(targets []testTarget) string {
+	ids := []string{}
+	for i := range targets {
+		ids = append(ids, fmt.Sprintf("T%d", i))
+	}
+	return strings.Join(ids, ", ")
+}
+
+func createTestScheme(t *testing.T, containerPth, name, buildableName, blueprintName, referencedContainer string) {
+	content := fmt.Sprintf(testSchemeContentFormat, buildableName, blueprintName, referencedContainer)
+	createTestFile(t, filepath.Join(containerPth, "xcshareddata", "xcschemes", name+".xcscheme"), content)
+}
+
+func createTestDir(t *testing.T) string {
+	tmpDir, err := ioutil.TempDir("", "project_detection")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+	}
+	// the tmp dir might be a symlink (macOS)
+	tmpDir, err = filepath.EvalSymlinks(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to resolve tmp dir: %s", err)
+	}
+	return tmpDir
+}
+
+func TestFindProjectsAndWorkspaces(t *testing.T) {
+	tmpDir := createTestDir(t)
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Logf("failed to remove tmp dir: %s", err)
+		}
+	}()
+
+	for _, dir := range []string{
+		"App.xcodeproj/project.xcworkspace",
+		"App.xcworkspace",
+		"Modules/Module.xcodeproj",
+		"Pods/Pods.xcodeproj",
+		"Carthage/Checkouts/Lib/Lib.xcodeproj",
+		"node_modules/lib/Lib.xcworkspace",
+		"DerivedData/App/SourcePackages/checkouts/Package/Package.xcodeproj",
+		".git/App.xcodeproj",
+	} {
+		if err := os.MkdirAll(filepath.Join(tmpDir, dir), 0755); err != nil {
+			t.Fatalf("failed to create dir: %s", err)
+		}
+	}
+	// a file named like a project is not a project
+	createTestFile(t, filepath.Join(tmpDir, "Docs", "Old.xcodeproj"), "")
+
+	projects, workspaces, err := findProjectsAndWorkspaces(tmpDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	wantProjects := []string{filepath.Join(tmpDir, "App.xcodeproj"), filepath.Join(tmpDir, "Modules", "Module.xcodeproj")}
+	if !reflect.DeepEqual(projects, wantProjects) {
+		t.Errorf("got projects %q, want %q", projects, wantProjects)
+	}
+	wantWorkspaces := []string{filepath.Join(tmpDir, "App.xcworkspace")}
+	if !reflect.DeepEqual(workspaces, wantWorkspaces) {
+		t.Errorf("got workspaces %q, want %q", workspaces, wantWorkspaces)
+	}
+
+	if _, _, err := findProjectsAndWorkspaces(filepath.Join(tmpDir, "not_existing")); err == nil {
+		t.Errorf("expected error for not existing search dir")
+	}
+}
+
+func TestDetectProjectPath(t *testing.T) {
+	tmpDir := createTestDir(t)
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Logf("failed to remove tmp dir: %s", err)
+		}
+	}()
+
+	mkdir := func(pth string) {
+		if err := os.MkdirAll(pth, 0755); err != nil {
+			t.Fatalf("failed to create dir: %s", err)
+		}
+	}
+
+	t.Log("no project")
+	{
+		if _, err := detectProjectPath(tmpDir); err == nil {
+			t.Errorf("expected error if no project found")
+		}
+	}
+
+	t.Log("single project")
+	{
+		mkdir(filepath.Join(tmpDir, "App.xcodeproj"))
+		got, err := detectProjectPath(tmpDir)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if want := filepath.Join(tmpDir, "App.xcodeproj"); got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	}
+
+	t.Log("multiple projects")
+	{
+		mkdir(filepath.Join(tmpDir, "Other", "Other.xcodeproj"))
+		_, err := detectProjectPath(tmpDir)
+		if err == nil {
+			t.Fatalf("expected error for multiple projects")
+		}
+		if !strings.Contains(err.Error(), filepath.Join(tmpDir, "Other", "Other.xcodeproj")) {
+			t.Errorf("the error should list the candidates, got: %s", err)
+		}
+	}
+
+	t.Log("the workspace takes precedence over the projects")
+	{
+		mkdir(filepath.Join(tmpDir, "App.xcworkspace"))
+		got, err := detectProjectPath(tmpDir)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if want := filepath.Join(tmpDir, "App.xcworkspace"); got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	}
+}
+
+func TestDetectScheme(t *testing.T) {
+	tmpDir := createTestDir(t)
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Logf("failed to remove tmp dir: %s", err)
+		}
+	}()
+
+	t.Log("project: only the scheme of the macOS application target is detected")
+	{
+		projectPth := filepath.Join(tmpDir, "Project", "App.xcodeproj")
+		createTestProject(t, projectPth, "macosx",
+			testTarget{name: "MacApp"},
+			testTarget{name: "iOSApp", sdk: "iphoneos"},
+		)
+		createTestScheme(t, projectPth, "MacApp", "MacApp.app", "MacApp", "container:App.xcodeproj")
+		createTestScheme(t, projectPth, "iOSApp", "iOSApp.app", "iOSApp", "container:App.xcodeproj")
+		createTestScheme(t, projectPth, "Framework", "Framework.framework", "Framework", "container:App.xcodeproj")
+
+		got, err := detectScheme(projectPth)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if got != "MacApp" {
+			t.Errorf("got %s, want MacApp", got)
+		}
+	}
+
+	t.Log("workspace: the iOS scheme is not detected, even if a project of the workspace contains the macOS SDK")
+	{
+		workspaceDir := filepath.Join(tmpDir, "Workspace")
+		workspacePth := filepath.Join(workspaceDir, "App.xcworkspace")
+		createTestFile(t, filepath.Join(workspacePth, "contents.xcworkspacedata"), testWorkspaceContent)
+
+		createTestProject(t, filepath.Join(workspaceDir, "iOS", "iOS.xcodeproj"), "iphoneos", testTarget{name: "iOSApp"})
+		createTestProject(t, filepath.Join(workspaceDir, "Mac", "Mac.xcodeproj"), "macosx", testTarget{name: "Helper"}, testTarget{name: "MacApp", sdk: "macosx10.15"})
+		createTestScheme(t, filepath.Join(workspaceDir, "iOS", "iOS.xcodeproj"), "iOSApp", "iOSApp.app", "iOSApp", "container:iOS.xcodeproj")
+		// the workspace scheme refers the project relative to the workspace
+		createTestScheme(t, workspacePth, "MacApp", "MacApp.app", "MacApp", "container:Mac/Mac.xcodeproj")
+
+		got, err := detectScheme(workspacePth)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if got != "MacApp" {
+			t.Errorf("got %s, want MacApp", got)
+		}
+
+		t.Log("multiple macOS application schemes")
+		{
+			createTestScheme(t, filepath.Join(workspaceDir, "Mac", "Mac.xcodeproj"), "Helper", "Helper.app", "Helper", "container:Mac.xcodeproj")
+
+			_, err := detectScheme(workspacePth)
+			if err == nil {
+				t.Fatalf("expected error for multiple candidates")
+			}
+			if !strings.Contains(err.Error(), "- Helper\n- MacApp") {
+				t.Errorf("the error should list the candidates, got: %s", err)
+			}
+		}
+	}
+
+	t.Log("no macOS application scheme")
+	{
+		projectPth := filepath.Join(tmpDir, "iOSOnly", "App.xcodeproj")
+		createTestProject(t, projectPth, "iphoneos", testTarget{name: "iOSApp"})
+		createTestScheme(t, projectPth, "iOSApp", "iOSApp.app", "iOSApp", "container:App.xcodeproj")
+
+		_, err := detectScheme(projectPth)
+		if err == nil {
+			t.Fatalf("expected error if no scheme builds a macOS application")
+		}
+		if !strings.Contains(err.Error(), "- iOSApp") {
+			t.Errorf("the error should list the shared schemes, got: %s", err)
+		}
+	}
+
+	t.Log("the referred project does not exist")
+	{
+		projectPth := filepath.Join(tmpDir, "Missing", "App.xcodeproj")
+		createTestProject(t, projectPth, "macosx", testTarget{name: "MacApp"})
+		createTestScheme(t, projectPth, "MacApp", "MacApp.app", "MacApp", "container:Other.xcodeproj")
+
+		if _, err := detectScheme(projectPth); err == nil {
+			t.Errorf("expected error if no scheme builds a macOS application")
+		}
+	}
+
+	t.Log("no shared scheme")
+	{
+		projectPth := filepath.Join(tmpDir, "NoScheme", "App.xcodeproj")
+		createTestProject(t, projectPth, "macosx", testTarget{name: "MacApp"})
+
+		if _, err := detectScheme(projectPth); err == nil {
+			t.Errorf("expected error if no shared scheme found")
+		}
+	}
+}
+
+const testWorkspaceContent = `<?xml version="1.0" encoding="UTF-8"?>
+<Workspace
+   version = "1.0">
+   <FileRef
+      location = "group:iOS/iOS.xcodeproj">
+   </FileRef>
+   <FileRef
+      location = "group:Mac/Mac.xcodeproj">
+   </FileRef>
+</Workspace>
+`
+
+const testSchemeContentFormat = `<?xml version="1.0" encoding="UTF-8"?>
+<Scheme
+   LastUpgradeVersion = "1500"
+   version = "1.7">
+   <BuildAction
+      parallelizeBuildables = "YES"
+      buildImplicitDependencies = "YES">
+      <BuildActionEntries>
+         <BuildActionEntry
+            buildForTesting = "YES"
+            buildForRunning = "YES"
+            buildForProfiling = "YES"
+            buildForArchiving = "YES"
+            buildForAnalyzing = "YES">
+            <BuildableReference
+               BuildableIdentifier = "primary"
+               BlueprintIdentifier = "T0"
+               BuildableName = "%s"
+               BlueprintName = "%s"
+               ReferencedContainer = "%s">
+            </BuildableReference>
+         </BuildActionEntry>
+      </BuildActionEntries>
+   </BuildAction>
+   <ArchiveAction
+      buildConfiguration = "Release"
+      revealArchiveInOrganizer = "YES">
+   </ArchiveAction>
+</Scheme>
+`
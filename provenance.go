@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/bitrise-io/go-utils/command/git"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-tools/go-xcode/models"
+)
+
+const (
+	bitriseProvenancePathEnvKey = "BITRISE_PROVENANCE_PATH"
+
+	inTotoStatementType   = "https://in-toto.io/Statement/v0.1"
+	slsaProvenanceType    = "https://slsa.dev/provenance/v0.2"
+	provenanceBuildType   = "https://github.com/bitrise-steplib/steps-xcode-archive-mac"
+	provenanceDefaultID   = "https://github.com/bitrise-steplib/steps-xcode-archive-mac"
+	sha256DigestAlgorithm = "sha256"
+	gitDigestAlgorithm    = "sha1"
+)
+
+// provenanceSubject is an artifact (or a Mach-O binary of the exported app) with its digest.
+type provenanceSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type provenanceBuilder struct {
+	ID string `json:"id"`
+}
+
+type provenanceInvocation struct {
+	Parameters  map[string]string `json:"parameters"`
+	Environment map[string]string `json:"environment"`
+}
+
+type provenanceMaterial struct {
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+type provenancePredicate struct {
+	Builder    provenanceBuilder    `json:"builder"`
+	BuildType  string               `json:"buildType"`
+	Invocation provenanceInvocation `json:"invocation"`
+	Materials  []provenanceMaterial `json:"materials"`
+}
+
+// provenanceStatement is an in-toto statement with a SLSA provenance predicate.
+type provenanceStatement struct {
+	Type          string              `json:"_type"`
+	Subject       []provenanceSubject `json:"subject"`
+	PredicateType string              `json:"predicateType"`
+	Predicate     provenancePredicate `json:"predicate"`
+}
+
+// fileSHA256 returns the hex encoded SHA-256 digest of the file.
+func fileSHA256(pth string) (string, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Warnf("Failed to close file (%s), error: %s", pth, err)
+		}
+	}()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// isMachO checks the magic number of the file: thin (32 and 64 bit, both byte orders) and universal binaries.
+func isMachO(pth string) (bool, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Warnf("Failed to close file (%s), error: %s", pth, err)
+		}
+	}()
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(f, header); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	switch binary.BigEndian.Uint32(header) {
+	case 0xfeedface, 0xfeedfacf, 0xcefaedfe, 0xcffaedfe:
+		return true, nil
+	case 0xcafebabe:
+		// Java class files share the universal binary's magic, their version (in place of the architecture count) is above 44
+		return binary.BigEndian.Uint32(header[4:]) < 45, nil
+	}
+	return false, nil
+}
+
+// machOSubjects returns the Mach-O binaries of the app, named relative to the app's parent directory.
+// The symbolic links (like the framework's Versions/Current) are skipped, the binaries they point to are listed anyway.
+func machOSubjects(appPth string) ([]provenanceSubject, error) {
+	subjects := []provenanceSubject{}
+	baseDir := filepath.Dir(appPth)
+
+	err := filepath.Walk(appPth, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		if machO, err := isMachO(pth); err != nil {
+			return err
+		} else if !machO {
+			return nil
+		}
+
+		digest, err := fileSHA256(pth)
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(baseDir, pth)
+		if err != nil {
+			return err
+		}
+
+		subjects = append(subjects, provenanceSubject{
+			Name:   filepath.ToSlash(name),
+			Digest: map[string]string{sha256DigestAlgorithm: digest},
+		})
+		return nil
+	})
+	return subjects, err
+}
+
+// gitCommit returns the checked out commit of the repository containing the given directory.
+func gitCommit(dir string) (string, error) {
+	repository, err := git.New(dir)
+	if err != nil {
+		return "", err
+	}
+
+	cmd := repository.RevParse("HEAD")
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s failed, output: %s, error: %s", cmd.PrintableCommandArgs(), out, err)
+	}
+	return out, nil
+}
+
+// newProvenanceStatement creates the provenance of the artifacts and of the app's Mach-O binaries.
+func newProvenanceStatement(artifactPths []string, appPth string, configs ConfigsModel, xcodebuildVersion models.XcodebuildVersionModel, metadata archiveMetadata) (provenanceStatement, error) {
+	subjects := []provenanceSubject{}
+	for _, pth := range artifactPths {
+		digest, err := fileSHA256(pth)
+		if err != nil {
+			return provenanceStatement{}, fmt.Errorf("failed to calculate the checksum of %s, error: %s", pth, err)
+		}
+		subjects = append(subjects, provenanceSubject{
+			Name:   filepath.Base(pth),
+			Digest: map[string]string{sha256DigestAlgorithm: digest},
+		})
+	}
+
+	if appPth != "" {
+		machOs, err := machOSubjects(appPth)
+		if err != nil {
+			return provenanceStatement{}, fmt.Errorf("failed to calculate the checksum of the app's binaries, error: %s", err)
+		}
+		subjects = append(subjects, machOs...)
+	}
+
+	sort.Slice(subjects, func(i, j int) bool { return subjects[i].Name < subjects[j].Name })
+
+	material := provenanceMaterial{URI: os.Getenv("GIT_REPOSITORY_URL")}
+	if commit, err := gitCommit(filepath.Dir(configs.ProjectPath)); err != nil {
+		log.Warnf("Failed to read the git commit, error: %s", err)
+	} else {
+		material.Digest = map[string]string{gitDigestAlgorithm: commit}
+	}
+
+	builderID := os.Getenv("BITRISE_BUILD_URL")
+	if builderID == "" {
+		builderID = provenanceDefaultID
+	}
+
+	return provenanceStatement{
+		Type:          inTotoStatementType,
+		Subject:       subjects,
+		PredicateType: slsaProvenanceType,
+		Predicate: provenancePredicate{
+			Builder:   provenanceBuilder{ID: builderID},
+			BuildType: provenanceBuildType,
+			Invocation: provenanceInvocation{
+				Parameters: map[string]string{
+					"scheme":           configs.Scheme,
+					"configuration":    configs.Configuration,
+					"export_method":    metadata.ExportMethod,
+					"signing_identity": metadata.SigningIdentity,
+				},
+				Environment: map[string]string{
+					"xcode_version":       xcodebuildVersion.Version,
+					"xcode_build_version": xcodebuildVersion.BuildVersion,
+				},
+			},
+			Materials: []provenanceMaterial{material},
+		},
+	}, nil
+}
+
+// writeProvenance writes the provenance statement as indented JSON and prints the checksums.
+func writeProvenance(statement provenanceStatement, pth string) error {
+	content, err := json.MarshalIndent(statement, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	for _, subject := range statement.Subject {
+		log.Printf("%s  %s", subject.Digest[sha256DigestAlgorithm], subject.Name)
+	}
+
+	return fileutil.WriteBytesToFile(pth, append(content, '\n'))
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-tools/go-xcode/models"
+)
+
+// setenvForTest sets the environment variable and returns the function restoring its original value.
+func setenvForTest(t *testing.T, key, value string) func() {
+	origValue, isSet := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatalf("failed to set %s: %s", key, err)
+	}
+	return func() {
+		restore := func() error { return os.Unsetenv(key) }
+		if isSet {
+			restore = func() error { return os.Setenv(key, origValue) }
+		}
+		if err := restore(); err != nil {
+			t.Logf("failed to restore %s: %s", key, err)
+		}
+	}
+}
+
+func testSHA256(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestIsMachO(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "provenance")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Logf("failed to remove tmp dir: %s", err)
+		}
+	}()
+
+	for _, tt := range []struct {
+		name    string
+		content []byte
+		want    bool
+	}{
+		{name: "64 bit", content: testMachOContent, want: true},
+		{name: "32 bit", content: []byte("\xce\xfa\xed\xfe\x07\x00\x00\x00"), want: true},
+		{name: "64 bit big endian", content: []byte("\xfe\xed\xfa\xcf\x01\x00\x00\x07"), want: true},
+		{name: "universal", content: []byte("\xca\xfe\xba\xbe\x00\x00\x00\x02"), want: true},
+		{name: "Java class", content: []byte("\xca\xfe\xba\xbe\x00\x00\x00\x34"), want: false},
+		{name: "script", content: []byte("#!/bin/sh\necho hello\n"), want: false},
+		{name: "short", content: []byte("\xcf\xfa\xed"), want: false},
+		{name: "empty", content: []byte{}, want: false},
+	} {
+		pth := filepath.Join(tmpDir, tt.name)
+		if err := ioutil.WriteFile(pth, tt.content, 0644); err != nil {
+			t.Fatalf("failed to write file: %s", err)
+		}
+		if got, err := isMachO(pth); err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+		} else if got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewProvenanceStatement(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "provenance")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Logf("failed to remove tmp dir: %s", err)
+		}
+	}()
+
+	defer setenvForTest(t, "GIT_REPOSITORY_URL", "https://github.com/bitrise-io/sample-apps-osx.git")()
+	defer setenvForTest(t, "BITRISE_BUILD_URL", "https://app.bitrise.io/build/1234")()
+
+	// the project's repository
+	projectDir := filepath.Join(tmpDir, "project")
+	if err := os.MkdirAll(projectDir, 0755); err != nil {
+		t.Fatalf("failed to create project dir: %s", err)
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"-c", "user.name=Bitrise", "-c", "user.email=bot@bitrise.io", "commit", "-q", "--allow-empty", "-m", "Initial commit"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = projectDir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s failed, output: %s, error: %s", strings.Join(args, " "), out, err)
+		}
+	}
+	commit, err := gitCommit(projectDir)
+	if err != nil {
+		t.Fatalf("failed to read the commit: %s", err)
+	}
+
+	archivePth := createTestArchive(t, tmpDir, "App", "42", "2.1.0")
+	appPth := filepath.Join(archivePth, "Products", "Applications", "App.app")
+
+	artifacts := map[string][]byte{
+		filepath.Join(tmpDir, "App.app.zip"): []byte("zipped app"),
+		filepath.Join(tmpDir, "App.pkg"):     []byte("installer package"),
+	}
+	artifactPths := []string{}
+	for pth, content := range artifacts {
+		if err := ioutil.WriteFile(pth, content, 0644); err != nil {
+			t.Fatalf("failed to write artifact: %s", err)
+		}
+		artifactPths = append(artifactPths, pth)
+	}
+
+	configs := ConfigsModel{
+		ProjectPath:   filepath.Join(projectDir, "App.xcodeproj"),
+		Scheme:        "App",
+		Configuration: "Release",
+	}
+	xcodebuildVersion := models.XcodebuildVersionModel{Version: "13.0", BuildVersion: "13A233"}
+	metadata := archiveMetadata{ExportMethod: "developer-id", SigningIdentity: "Developer ID Application: Bitrise Ltd. (TEAM111111)"}
+
+	t.Log("artifacts and the app's Mach-O binaries are the subjects")
+	{
+		statement, err := newProvenanceStatement(artifactPths, appPth, configs, xcodebuildVersion, metadata)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		machODigest := testSHA256(testMachOContent)
+		wantSubjects := []provenanceSubject{
+			{Name: "App.app.zip", Digest: map[string]string{"sha256": testSHA256([]byte("zipped app"))}},
+			{Name: "App.app/Contents/Frameworks/Kit.framework/Versions/A/Kit", Digest: map[string]string{"sha256": machODigest}},
+			{Name: "App.app/Contents/MacOS/App", Digest: map[string]string{"sha256": machODigest}},
+			{Name: "App.app/Contents/PlugIns/Widget.appex/Contents/MacOS/Widget", Digest: map[string]string{"sha256": machODigest}},
+			{Name: "App.pkg", Digest: map[string]string{"sha256": testSHA256([]byte("installer package"))}},
+		}
+		if !reflect.DeepEqual(statement.Subject, wantSubjects) {
+			t.Errorf("got subjects %+v, want %+v", statement.Subject, wantSubjects)
+		}
+
+		provenancePth := filepath.Join(tmpDir, "provenance.json")
+		if err := writeProvenance(statement, provenancePth); err != nil {
+			t.Fatalf("failed to write provenance: %s", err)
+		}
+		content, err := ioutil.ReadFile(provenancePth)
+		if err != nil {
+			t.Fatalf("failed to read provenance: %s", err)
+		}
+
+		var got map[string]interface{}
+		if err := json.Unmarshal(content, &got); err != nil {
+			t.Fatalf("failed to parse provenance: %s", err)
+		}
+		var want map[string]interface{}
+		if err := json.Unmarshal([]byte(`{
+  "_type": "https://in-toto.io/Statement/v0.1",
+  "subject": [
+    {"name": "App.app.zip", "digest": {"sha256": "`+testSHA256([]byte("zipped app"))+`"}},
+    {"name": "App.app/Contents/Frameworks/Kit.framework/Versions/A/Kit", "digest": {"sha256": "`+machODigest+`"}},
+    {"name": "App.app/Contents/MacOS/App", "digest": {"sha256": "`+machODigest+`"}},
+    {"name": "App.app/Contents/PlugIns/Widget.appex/Contents/MacOS/Widget", "digest": {"sha256": "`+machODigest+`"}},
+    {"name": "App.pkg", "digest": {"sha256": "`+testSHA256([]byte("installer package"))+`"}}
+  ],
+  "predicateType": "https://slsa.dev/provenance/v0.2",
+  "predicate": {
+    "builder": {"id": "https://app.bitrise.io/build/1234"},
+    "buildType": "https://github.com/bitrise-steplib/steps-xcode-archive-mac",
+    "invocation": {
+      "parameters": {
+        "scheme": "App",
+        "configuration": "Release",
+        "export_method": "developer-id",
+        "signing_identity": "Developer ID Application: Bitrise Ltd. (TEAM111111)"
+      },
+      "environment": {"xcode_version": "13.0", "xcode_build_version": "13A233"}
+    },
+    "materials": [
+      {"uri": "https://github.com/bitrise-io/sample-apps-osx.git", "digest": {"sha1": "`+commit+`"}}
+    ]
+  }
+}`), &want); err != nil {
+			t.Fatalf("failed to parse the expected provenance: %s", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got provenance:\n%s\nwant:\n%v", content, want)
+		}
+		if !strings.HasSuffix(string(content), "}\n") {
+			t.Errorf("provenance does not end with a newline")
+		}
+	}
+
+	t.Log("archive only: only the artifacts are the subjects, the default builder is used outside of Bitrise")
+	{
+		defer setenvForTest(t, "BITRISE_BUILD_URL", "")()
+
+		statement, err := newProvenanceStatement([]string{filepath.Join(tmpDir, "App.pkg")}, "", configs, xcodebuildVersion, metadata)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(statement.Subject) != 1 || statement.Subject[0].Name != "App.pkg" {
+			t.Errorf("unexpected subjects: %+v", statement.Subject)
+		}
+		if statement.Predicate.Builder.ID != provenanceDefaultID {
+			t.Errorf("got builder %s, want %s", statement.Predicate.Builder.ID, provenanceDefaultID)
+		}
+	}
+
+	t.Log("project outside of a git repository")
+	{
+		configs := configs
+		configs.ProjectPath = filepath.Join(archivePth, "App.xcodeproj")
+
+		statement, err := newProvenanceStatement(nil, "", configs, xcodebuildVersion, metadata)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		wantMaterials := []provenanceMaterial{{URI: "https://github.com/bitrise-io/sample-apps-osx.git"}}
+		if !reflect.DeepEqual(statement.Predicate.Materials, wantMaterials) {
+			t.Errorf("got materials %+v, want %+v", statement.Predicate.Materials, wantMaterials)
+		}
+	}
+
+	t.Log("missing artifact")
+	{
+		if _, err := newProvenanceStatement([]string{filepath.Join(tmpDir, "Missing.pkg")}, appPth, configs, xcodebuildVersion, metadata); err == nil {
+			t.Errorf("expected error for the missing artifact")
+		}
+	}
+}
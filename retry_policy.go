@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/retry"
+)
+
+// transientFailure is a known intermittent failure of xcodebuild or codesign, worth retrying.
+type transientFailure struct {
+	Name    string
+	Pattern *regexp.Regexp
+	// CleanDerivedData marks the failures caused by a corrupted or locked DerivedData.
+	CleanDerivedData bool
+}
+
+// transientFailures is the catalogue of the known transient failures, matched against the captured xcodebuild output.
+var transientFailures = []transientFailure{
+	{
+		Name:             "build database is locked",
+		Pattern:          regexp.MustCompile(`unable to attach DB|accessing build database .* database is locked`),
+		CleanDerivedData: true,
+	},
+	{
+		Name:             "build system is disabled",
+		Pattern:          regexp.MustCompile(`(?i)the build system has been disabled`),
+		CleanDerivedData: true,
+	},
+	{
+		Name:             "build service crashed",
+		Pattern:          regexp.MustCompile(`The Xcode build system has crashed|Build service could not create build operation|Lost connection to the build service`),
+		CleanDerivedData: true,
+	},
+	{
+		Name:    "keychain is not accessible by codesign",
+		Pattern: regexp.MustCompile(`errSecInternalComponent`),
+	},
+	{
+		Name:    "timestamp service is not available",
+		Pattern: regexp.MustCompile(`(?i)the timestamp service is not available|a timestamp was expected but was not found`),
+	},
+	{
+		Name:    "helper application is not available",
+		Pattern: regexp.MustCompile(`Couldn't communicate with a helper application`),
+	},
+}
+
+// classifyFailure returns the transient failure matching the output of a failed command, or nil if the failure is not known to be transient.
+func classifyFailure(out string) *transientFailure {
+	for i, failure := range transientFailures {
+		if failure.Pattern.MatchString(out) {
+			return &transientFailures[i]
+		}
+	}
+	return nil
+}
+
+// retryPolicy retries the failed archive and export commands, if they failed with a transient failure.
+type retryPolicy struct {
+	retries          uint
+	wait             time.Duration
+	cleanDerivedData bool
+	derivedDataPath  string
+}
+
+// attemptReport is the outcome of an attempt, failure is only set if the attempt failed with a transient failure.
+type attemptReport struct {
+	attempt  uint
+	duration time.Duration
+	err      error
+	failure  *transientFailure
+}
+
+// newRetryPolicy creates the retry policy from the (validated) retry inputs.
+func newRetryPolicy(configs ConfigsModel, derivedDataPath string) retryPolicy {
+	retries, _ := strconv.Atoi(configs.RetryCount)
+	waitSeconds, _ := strconv.Atoi(configs.RetryWaitSeconds)
+	return retryPolicy{
+		retries:          uint(retries),
+		wait:             time.Duration(waitSeconds) * time.Second,
+		cleanDerivedData: configs.IsCleanDerivedDataOnRetry == "yes",
+		derivedDataPath:  derivedDataPath,
+	}
+}
+
+// run runs the action, which returns the command's captured output, and retries it while it fails with a transient failure.
+// It returns the output and the error of the last attempt, and the report of every attempt.
+func (policy retryPolicy) run(phase string, action func(attempt uint) (string, error)) (string, []attemptReport, error) {
+	reports := []attemptReport{}
+	out := ""
+	var permanentErr error
+
+	err := retry.Times(policy.retries).Wait(policy.wait).Try(func(attempt uint) error {
+		if attempt > 0 {
+			fmt.Println()
+			log.Warnf("Retrying %s, attempt %d/%d ...", phase, attempt+1, policy.retries+1)
+		}
+
+		start := time.Now()
+		var err error
+		out, err = action(attempt)
+
+		report := attemptReport{attempt: attempt + 1, duration: time.Since(start), err: err}
+		// a command terminated because of a timeout or an interrupt is not retried
+		if err != nil && err != context.DeadlineExceeded && err != context.Canceled {
+			report.failure = classifyFailure(out)
+		}
+		reports = append(reports, report)
+
+		if err == nil {
+			return nil
+		}
+		if report.failure == nil {
+			// not worth retrying, stop with the failure
+			permanentErr = err
+			return nil
+		}
+
+		log.Warnf("The %s attempt %d failed with a transient failure: %s", phase, attempt+1, report.failure.Name)
+		if attempt < policy.retries {
+			policy.beforeRetry(*report.failure)
+		}
+		return err
+	})
+	if permanentErr != nil {
+		err = permanentErr
+	}
+	return out, reports, err
+}
+
+// beforeRetry cleans the DerivedData, if enabled and the failure is caused by the DerivedData.
+// Only the DerivedData directory owned by the step is cleaned, Xcode's default location is shared by every project on the machine.
+func (policy retryPolicy) beforeRetry(failure transientFailure) {
+	if !policy.cleanDerivedData || !failure.CleanDerivedData {
+		return
+	}
+	if policy.derivedDataPath == "" {
+		log.Warnf("DerivedDataPath is not set, Xcode's default DerivedData location is not cleaned before the retry")
+		return
+	}
+
+	log.Printf("Cleaning DerivedData (%s) before the retry", policy.derivedDataPath)
+	if err := os.RemoveAll(policy.derivedDataPath); err != nil {
+		log.Warnf("Failed to clean DerivedData, error: %s", err)
+	}
+}
+
+// printAttemptSummary prints the outcome of the attempts, if the command was retried.
+func printAttemptSummary(phase string, reports []attemptReport) {
+	if len(reports) < 2 {
+		return
+	}
+
+	fmt.Println()
+	log.Infof("%s attempts:", phase)
+	for _, report := range reports {
+		duration := report.duration.Round(time.Second)
+		switch {
+		case report.err == nil:
+			log.Donef("- attempt %d: succeeded (%s)", report.attempt, duration)
+		case report.failure != nil:
+			log.Warnf("- attempt %d: failed with a transient failure: %s (%s)", report.attempt, report.failure.Name, duration)
+		default:
+			log.Errorf("- attempt %d: failed (%s), error: %s", report.attempt, duration, report.err)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	for _, tt := range []struct {
+		out              string
+		want             string
+		cleanDerivedData bool
+	}{
+		{
+			out:              "error: unable to attach DB: error: accessing build database \"/Users/vagrant/Library/Developer/Xcode/DerivedData/App/Build/Intermediates.noindex/XCBuildData/build.db\": database is locked",
+			want:             "build database is locked",
+			cleanDerivedData: true,
+		},
+		{
+			out:              "error: accessing build database \"build.db\": database is locked Possibly there are two concurrent builds running in the same filesystem location.",
+			want:             "build database is locked",
+			cleanDerivedData: true,
+		},
+		{
+			out:              "The build system has been disabled: the build service is not available",
+			want:             "build system is disabled",
+			cleanDerivedData: true,
+		},
+		{
+			out:              "error: The Xcode build system has crashed. Build again to continue.",
+			want:             "build service crashed",
+			cleanDerivedData: true,
+		},
+		{
+			out:              "error: Build service could not create build operation: unknown error while handling message",
+			want:             "build service crashed",
+			cleanDerivedData: true,
+		},
+		{
+			out:              "error: Lost connection to the build service",
+			want:             "build service crashed",
+			cleanDerivedData: true,
+		},
+		{
+			out:  "/Users/vagrant/Library/Developer/Xcode/DerivedData/App/Build/Products/Release/App.app: errSecInternalComponent\nCommand CodeSign failed with a nonzero exit code",
+			want: "keychain is not accessible by codesign",
+		},
+		{
+			out:  "App.app: The timestamp service is not available.",
+			want: "timestamp service is not available",
+		},
+		{
+			out:  "App.app: A timestamp was expected but was not found.",
+			want: "timestamp service is not available",
+		},
+		{
+			out:  "error: exportArchive: Couldn't communicate with a helper application.",
+			want: "helper application is not available",
+		},
+		{
+			out:  "AppDelegate.swift:12:9: error: cannot find 'undefinedSymbol' in scope\n** ARCHIVE FAILED **",
+			want: "",
+		},
+		{
+			out:  "error: No signing certificate \"Developer ID Application\" found",
+			want: "",
+		},
+		{
+			out:  "",
+			want: "",
+		},
+	} {
+		failure := classifyFailure(tt.out)
+		if tt.want == "" {
+			if failure != nil {
+				t.Errorf("classifyFailure(%q) = %s, want no transient failure", tt.out, failure.Name)
+			}
+			continue
+		}
+
+		if failure == nil {
+			t.Errorf("classifyFailure(%q) = nil, want %s", tt.out, tt.want)
+			continue
+		}
+		if failure.Name != tt.want || failure.CleanDerivedData != tt.cleanDerivedData {
+			t.Errorf("classifyFailure(%q) = %s (clean DerivedData: %v), want %s (clean DerivedData: %v)", tt.out, failure.Name, failure.CleanDerivedData, tt.want, tt.cleanDerivedData)
+		}
+	}
+
+	// the catalogue entries are told apart by their names in the attempt summary
+	for i, failure := range transientFailures {
+		for j, other := range transientFailures[:i] {
+			if other.Name == failure.Name {
+				t.Errorf("duplicated transient failure name at %d and %d: %s", j, i, failure.Name)
+			}
+		}
+	}
+}
+
+func TestRetryPolicyRun(t *testing.T) {
+	const lockedDBOut = "error: unable to attach DB: database is locked"
+
+	// runAttempts runs the policy with an action, which returns the outs and errors of the attempts in order.
+	runAttempts := func(policy retryPolicy, outs []string, errs []error) (string, []attemptReport, int, error) {
+		calls := 0
+		out, reports, err := policy.run("archive", func(attempt uint) (string, error) {
+			if int(attempt) != calls {
+				t.Errorf("got attempt %d, want %d", attempt, calls)
+			}
+			i := calls
+			calls++
+			if i >= len(outs) {
+				t.Fatalf("unexpected attempt: %d", attempt)
+			}
+			return outs[i], errs[i]
+		})
+		return out, reports, calls, err
+	}
+
+	t.Log("succeeded")
+	{
+		out, reports, calls, err := runAttempts(retryPolicy{retries: 2}, []string{"** ARCHIVE SUCCEEDED **"}, []error{nil})
+		if err != nil || out != "** ARCHIVE SUCCEEDED **" || calls != 1 || len(reports) != 1 {
+			t.Errorf("unexpected result: out: %q, err: %v, calls: %d, reports: %+v", out, err, calls, reports)
+		}
+	}
+
+	t.Log("transient failure is retried")
+	{
+		exitErr := fmt.Errorf("exit status 65")
+		out, reports, calls, err := runAttempts(retryPolicy{retries: 2}, []string{lockedDBOut, "** ARCHIVE SUCCEEDED **"}, []error{exitErr, nil})
+		if err != nil || out != "** ARCHIVE SUCCEEDED **" || calls != 2 {
+			t.Fatalf("unexpected result: out: %q, err: %v, calls: %d", out, err, calls)
+		}
+		if len(reports) != 2 || reports[0].failure == nil || reports[0].failure.Name != "build database is locked" || reports[1].err != nil {
+			t.Errorf("unexpected reports: %+v", reports)
+		}
+	}
+
+	t.Log("transient failure without retries left")
+	{
+		exitErr := fmt.Errorf("exit status 65")
+		out, reports, calls, err := runAttempts(retryPolicy{retries: 1}, []string{lockedDBOut, lockedDBOut}, []error{exitErr, exitErr})
+		if err != exitErr || out != lockedDBOut || calls != 2 || len(reports) != 2 {
+			t.Errorf("unexpected result: out: %q, err: %v, calls: %d, reports: %+v", out, err, calls, reports)
+		}
+	}
+
+	t.Log("no retries by default")
+	{
+		exitErr := fmt.Errorf("exit status 65")
+		_, reports, calls, err := runAttempts(retryPolicy{}, []string{lockedDBOut}, []error{exitErr})
+		if err != exitErr || calls != 1 || len(reports) != 1 || reports[0].failure == nil {
+			t.Errorf("unexpected result: err: %v, calls: %d, reports: %+v", err, calls, reports)
+		}
+	}
+
+	t.Log("permanent failure is not retried")
+	{
+		exitErr := fmt.Errorf("exit status 65")
+		out, reports, calls, err := runAttempts(retryPolicy{retries: 2}, []string{"error: cannot find 'undefinedSymbol' in scope"}, []error{exitErr})
+		if err != exitErr || out != "error: cannot find 'undefinedSymbol' in scope" || calls != 1 {
+			t.Fatalf("unexpected result: out: %q, err: %v, calls: %d", out, err, calls)
+		}
+		if len(reports) != 1 || reports[0].failure != nil {
+			t.Errorf("unexpected reports: %+v", reports)
+		}
+	}
+
+	t.Log("timed out or interrupted command is not retried, even with a transient failure's output")
+	{
+		for _, ctxErr := range []error{context.DeadlineExceeded, context.Canceled} {
+			out, reports, calls, err := runAttempts(retryPolicy{retries: 2}, []string{lockedDBOut}, []error{ctxErr})
+			if err != ctxErr || out != lockedDBOut || calls != 1 {
+				t.Errorf("%s: unexpected result: out: %q, err: %v, calls: %d", ctxErr, out, err, calls)
+			}
+			if len(reports) != 1 || reports[0].failure != nil || reports[0].err != ctxErr {
+				t.Errorf("%s: unexpected reports: %+v", ctxErr, reports)
+			}
+		}
+	}
+}
+
+func TestRetryPolicyBeforeRetry(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "retry")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Logf("failed to remove tmp dir: %s", err)
+		}
+	}()
+
+	derivedDataPath := filepath.Join(tmpDir, "DerivedData")
+	createDerivedData := func() {
+		if err := os.MkdirAll(filepath.Join(derivedDataPath, "Build"), 0755); err != nil {
+			t.Fatalf("failed to create DerivedData: %s", err)
+		}
+	}
+	derivedDataExists := func() bool {
+		_, err := os.Stat(derivedDataPath)
+		return err == nil
+	}
+
+	lockedDB := *classifyFailure("unable to attach DB")
+	keychain := *classifyFailure("errSecInternalComponent")
+
+	t.Log("DerivedData failure cleans the DerivedData, if enabled")
+	{
+		createDerivedData()
+		retryPolicy{cleanDerivedData: true, derivedDataPath: derivedDataPath}.beforeRetry(lockedDB)
+		if derivedDataExists() {
+			t.Errorf("DerivedData is not cleaned")
+		}
+	}
+
+	t.Log("DerivedData is kept, if cleaning is disabled")
+	{
+		createDerivedData()
+		retryPolicy{derivedDataPath: derivedDataPath}.beforeRetry(lockedDB)
+		if !derivedDataExists() {
+			t.Errorf("DerivedData is cleaned")
+		}
+	}
+
+	t.Log("Xcode's default DerivedData is not cleaned, if the DerivedData path is not set")
+	{
+		defer setenvForTest(t, "HOME", tmpDir)()
+		defaultDerivedDataPath := filepath.Join(tmpDir, "Library", "Developer", "Xcode", "DerivedData")
+		if err := os.MkdirAll(filepath.Join(defaultDerivedDataPath, "Other-Project"), 0755); err != nil {
+			t.Fatalf("failed to create DerivedData: %s", err)
+		}
+
+		retryPolicy{cleanDerivedData: true}.beforeRetry(lockedDB)
+		if _, err := os.Stat(filepath.Join(defaultDerivedDataPath, "Other-Project")); err != nil {
+			t.Errorf("default DerivedData is cleaned: %s", err)
+		}
+	}
+
+	t.Log("DerivedData is kept, if the failure is not caused by the DerivedData")
+	{
+		createDerivedData()
+		retryPolicy{cleanDerivedData: true, derivedDataPath: derivedDataPath}.beforeRetry(keychain)
+		if !derivedDataExists() {
+			t.Errorf("DerivedData is cleaned")
+		}
+	}
+}
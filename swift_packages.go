@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-tools/go-xcode/xcodebuild"
+	"github.com/bitrise-tools/go-xcode/xcodeproj"
+)
+
+// fileChecksum returns the hex encoded SHA-256 checksum of the file.
+func fileChecksum(pth string) (string, error) {
+	file, err := os.Open(pth)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Warnf("Failed to close file (%s), error: %s", pth, err)
+		}
+	}()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// printSwiftPackagePins prints the resolved packages and their pinned version, branch or revision.
+func printSwiftPackagePins(resolved xcodeproj.PackageResolved) {
+	log.Printf("Package.resolved version: %d, %d package(s):", resolved.Version, len(resolved.Pins))
+	for _, pin := range resolved.Pins {
+		state := pin.Version
+		if state == "" && pin.Branch != "" {
+			state = "branch: " + pin.Branch
+		}
+		if state == "" {
+			state = "revision"
+		}
+
+		log.Printf("- %s (%s) %s @ %s", pin.Identity, pin.Location, state, pin.Revision)
+	}
+}
+
+// resolveSwiftPackages resolves the Swift package dependencies into the cacheable cloned source packages directory
+// and registers the directory in the build cache, keyed by the Package.resolved file's checksum.
+// Returns false if the project has no Package.resolved file.
+func resolveSwiftPackages(ctx context.Context, configs ConfigsModel, clonedSourcePackagesPath string, stepCache *buildCache) (bool, error) {
+	packageResolvedPth, err := xcodeproj.PackageResolvedPath(configs.ProjectPath)
+	if err != nil {
+		return false, err
+	}
+	if packageResolvedPth == "" {
+		return false, nil
+	}
+
+	resolved, err := xcodeproj.NewPackageResolvedFromFile(packageResolvedPth)
+	if err != nil {
+		return false, err
+	}
+	printSwiftPackagePins(resolved)
+
+	checksum, err := fileChecksum(packageResolvedPth)
+	if err != nil {
+		return false, fmt.Errorf("failed to calculate Package.resolved checksum, error: %s", err)
+	}
+	log.Printf("Package.resolved checksum: %s", checksum)
+
+	if err := os.MkdirAll(clonedSourcePackagesPath, 0755); err != nil {
+		return false, fmt.Errorf("failed to create cloned source packages dir (%s), error: %s", clonedSourcePackagesPath, err)
+	}
+
+	resolveCmd := xcodebuild.NewResolvePackageDependenciesCommand(configs.ProjectPath, xcodeproj.IsXCWorkspace(configs.ProjectPath))
+	resolveCmd.SetDir(configs.WorkDir)
+	resolveCmd.SetScheme(configs.Scheme)
+	resolveCmd.SetClonedSourcePackagesDirPath(clonedSourcePackagesPath)
+
+	log.TSuccessf("$ %s", resolveCmd.PrintableCmd())
+	fmt.Println()
+
+	if err := resolveCmd.RunWithContext(ctx); err != nil {
+		return true, fmt.Errorf("failed to resolve package dependencies, error: %s", err)
+	}
+
+	// the cache is invalidated if the Package.resolved file changes
+	if err := stepCache.register([]string{fmt.Sprintf("%s -> %s", clonedSourcePackagesPath, packageResolvedPth)}, nil); err != nil {
+		return true, fmt.Errorf("failed to register cloned source packages dir in the cache, error: %s", err)
+	}
+
+	return true, nil
+}
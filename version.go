@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-tools/go-xcode/plistutil"
+	"github.com/bitrise-tools/go-xcode/xcarchive"
+	"github.com/bitrise-tools/go-xcode/xcodeproj"
+)
+
+const (
+	versionUpdateMethodBuildSettings = "build_settings"
+	versionUpdateMethodInfoPlist     = "info_plist"
+)
+
+// bundleVersionRegexp matches the CFBundleVersion and CFBundleShortVersionString formats accepted by the App Store:
+// one to three period separated integers, like: 1.2.3
+var bundleVersionRegexp = regexp.MustCompile(`^[0-9]+(\.[0-9]+){0,2}$`)
+
+// resolveBuildNumber adds the offset to the build number, the offset is optional.
+func resolveBuildNumber(buildNumber, offset string) (string, error) {
+	if buildNumber == "" {
+		if offset != "" {
+			return "", fmt.Errorf("build number offset (%s) is set, but build number is empty", offset)
+		}
+		return "", nil
+	}
+	if offset == "" {
+		return buildNumber, nil
+	}
+
+	number, err := strconv.ParseInt(buildNumber, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("build number (%s) has to be an integer to apply the offset", buildNumber)
+	}
+	offsetNumber, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("build number offset (%s) is not an integer", offset)
+	}
+
+	resolved := number + offsetNumber
+	if resolved < 0 {
+		return "", fmt.Errorf("build number (%s) with the offset (%s) is negative: %d", buildNumber, offset, resolved)
+	}
+	return strconv.FormatInt(resolved, 10), nil
+}
+
+// validateBundleVersion returns error if the version is not in the period separated integers format.
+func validateBundleVersion(version string) error {
+	if version != "" && !bundleVersionRegexp.MatchString(version) {
+		return fmt.Errorf("invalid version (%s), expected format: one to three period separated integers, like: 1.2.3", version)
+	}
+	return nil
+}
+
+// schemeInfoPlistPaths returns the Info.plist files of the scheme's targets.
+func schemeInfoPlistPaths(configs ConfigsModel, customOptions []string) ([]string, error) {
+	overrides := codeSignBuildSettingOverrides(configs, customOptions)
+	codeSignInfoMap, err := xcodeproj.ResolveCodeSignInfoWithOverrides(configs.ProjectPath, configs.Scheme, configs.Configuration, os.Getenv("USER"), overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	pthMap := map[string]bool{}
+	for target, info := range codeSignInfoMap {
+		if info.InfoPlistPath == "" {
+			log.Warnf("No Info.plist file (INFOPLIST_FILE) set for target: %s", target)
+			continue
+		}
+		pthMap[info.InfoPlistPath] = true
+	}
+
+	pths := []string{}
+	for pth := range pthMap {
+		pths = append(pths, pth)
+	}
+	sort.Strings(pths)
+
+	return pths, nil
+}
+
+// updateInfoPlistVersions rewrites the CFBundleVersion and CFBundleShortVersionString of the given Info.plist files,
+// in the format the files are stored in.
+func updateInfoPlistVersions(infoPlistPths []string, buildNumber, marketingVersion string) error {
+	for _, pth := range infoPlistPths {
+		infoPlist, format, err := plistutil.NewPlistDataAndFormatFromFile(pth)
+		if err != nil {
+			return fmt.Errorf("failed to read Info.plist (%s), error: %s", pth, err)
+		}
+
+		if buildNumber != "" {
+			infoPlist["CFBundleVersion"] = buildNumber
+		}
+		if marketingVersion != "" {
+			infoPlist["CFBundleShortVersionString"] = marketingVersion
+		}
+
+		if err := infoPlist.WriteToFile(pth, format); err != nil {
+			return fmt.Errorf("failed to write Info.plist (%s), error: %s", pth, err)
+		}
+		log.Printf("- %s", pth)
+	}
+	return nil
+}
+
+// versionMismatches returns the differences between the requested and the Info.plist's versions.
+func versionMismatches(infoPlist plistutil.PlistData, buildNumber, marketingVersion string) []string {
+	mismatches := []string{}
+	if buildNumber != "" {
+		if version, _ := infoPlist.GetString("CFBundleVersion"); version != buildNumber {
+			mismatches = append(mismatches, fmt.Sprintf("CFBundleVersion is %s instead of %s", version, buildNumber))
+		}
+	}
+	if marketingVersion != "" {
+		if version, _ := infoPlist.GetString("CFBundleShortVersionString"); version != marketingVersion {
+			mismatches = append(mismatches, fmt.Sprintf("CFBundleShortVersionString is %s instead of %s", version, marketingVersion))
+		}
+	}
+	return mismatches
+}
+
+// verifyArchivedVersions returns error if the archived app does not carry the requested build number and version,
+// the mismatching app extensions are only reported.
+func verifyArchivedVersions(archive xcarchive.MacosArchive, buildNumber, marketingVersion string) error {
+	for _, extension := range archive.Application.Extensions {
+		for _, mismatch := range versionMismatches(extension.InfoPlist, buildNumber, marketingVersion) {
+			log.Warnf("%s: %s", extension.BundleIdentifier(), mismatch)
+		}
+	}
+
+	if mismatches := versionMismatches(archive.Application.InfoPlist, buildNumber, marketingVersion); len(mismatches) > 0 {
+		return fmt.Errorf("%s: %s", archive.Application.BundleIdentifier(), strings.Join(mismatches, ", "))
+	}
+	return nil
+}
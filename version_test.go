@@ -0,0 +1,252 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-tools/go-xcode/plistutil"
+	"github.com/bitrise-tools/go-xcode/xcarchive"
+	"howett.net/plist"
+)
+
+// testMachOContent is the header of a 64 bit Mach-O binary, followed by some content.
+var testMachOContent = []byte("\xcf\xfa\xed\xfe\x07\x00\x00\x01binary")
+
+// createTestArchive creates an xcarchive of an app (io.bitrise.app) with a widget extension (io.bitrise.app.widget)
+// in the given directory, both of them carrying the given build number and version.
+// The app's executable, framework and the extension's executable are Mach-O binaries, the framework's
+// Versions/Current is a symlink.
+func createTestArchive(t *testing.T, dir, name, buildNumber, version string) string {
+	archivePth := filepath.Join(dir, name+".xcarchive")
+	appPth := filepath.Join(archivePth, "Products", "Applications", "App.app")
+
+	plists := map[string]plistutil.PlistData{
+		filepath.Join(archivePth, "Info.plist"): {
+			"Name":           name,
+			"SchemeName":     name,
+			"ArchiveVersion": uint64(2),
+		},
+		filepath.Join(appPth, "Contents", "Info.plist"): {
+			"CFBundleIdentifier":         "io.bitrise.app",
+			"CFBundleExecutable":         "App",
+			"CFBundleVersion":            buildNumber,
+			"CFBundleShortVersionString": version,
+			"LSMinimumSystemVersion":     "10.15",
+		},
+		filepath.Join(appPth, "Contents", "PlugIns", "Widget.appex", "Contents", "Info.plist"): {
+			"CFBundleIdentifier":         "io.bitrise.app.widget",
+			"CFBundleExecutable":         "Widget",
+			"CFBundleVersion":            buildNumber,
+			"CFBundleShortVersionString": version,
+		},
+	}
+	for pth, content := range plists {
+		if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+			t.Fatalf("failed to create dir: %s", err)
+		}
+		if err := content.WriteToFile(pth, plist.XMLFormat); err != nil {
+			t.Fatalf("failed to write plist: %s", err)
+		}
+	}
+
+	files := map[string][]byte{
+		filepath.Join(appPth, "Contents", "MacOS", "App"):                                           testMachOContent,
+		filepath.Join(appPth, "Contents", "Frameworks", "Kit.framework", "Versions", "A", "Kit"):    testMachOContent,
+		filepath.Join(appPth, "Contents", "PlugIns", "Widget.appex", "Contents", "MacOS", "Widget"): testMachOContent,
+		filepath.Join(appPth, "Contents", "Resources", "Assets.car"):                                []byte("assets"),
+		filepath.Join(archivePth, "dSYMs", "App.app.dSYM", "Contents", "Resources", "DWARF", "App"): testMachOContent,
+	}
+	for pth, content := range files {
+		if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+			t.Fatalf("failed to create dir: %s", err)
+		}
+		if err := ioutil.WriteFile(pth, content, 0755); err != nil {
+			t.Fatalf("failed to write file: %s", err)
+		}
+	}
+	if err := os.Symlink("A", filepath.Join(appPth, "Contents", "Frameworks", "Kit.framework", "Versions", "Current")); err != nil {
+		t.Fatalf("failed to create symlink: %s", err)
+	}
+
+	return archivePth
+}
+
+func TestResolveBuildNumber(t *testing.T) {
+	for _, tt := range []struct {
+		buildNumber string
+		offset      string
+		want        string
+		wantErr     bool
+	}{
+		{buildNumber: "", offset: "", want: ""},
+		{buildNumber: "42", offset: "", want: "42"},
+		// the build number is used as is without an offset
+		{buildNumber: "1.2.3", offset: "", want: "1.2.3"},
+		{buildNumber: "42", offset: "1000", want: "1042"},
+		{buildNumber: "42", offset: "0", want: "42"},
+		{buildNumber: "42", offset: "-40", want: "2"},
+		{buildNumber: "42", offset: "-42", want: "0"},
+		{buildNumber: "42", offset: "-43", wantErr: true},
+		{buildNumber: "-1", offset: "0", wantErr: true},
+		{buildNumber: "1.2.3", offset: "1", wantErr: true},
+		{buildNumber: "42", offset: "1.5", wantErr: true},
+		{buildNumber: "42", offset: "one", wantErr: true},
+		{buildNumber: "", offset: "1", wantErr: true},
+	} {
+		got, err := resolveBuildNumber(tt.buildNumber, tt.offset)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("resolveBuildNumber(%q, %q) = %q, want error", tt.buildNumber, tt.offset, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("resolveBuildNumber(%q, %q): unexpected error: %s", tt.buildNumber, tt.offset, err)
+		} else if got != tt.want {
+			t.Errorf("resolveBuildNumber(%q, %q) = %q, want %q", tt.buildNumber, tt.offset, got, tt.want)
+		}
+	}
+}
+
+func TestValidateBundleVersion(t *testing.T) {
+	for _, version := range []string{"", "1", "1.2", "1.2.3", "2021.10.100"} {
+		if err := validateBundleVersion(version); err != nil {
+			t.Errorf("validateBundleVersion(%q): unexpected error: %s", version, err)
+		}
+	}
+	for _, version := range []string{"1.2.3.4", "v1.2", "1.2-beta", "1..2", ".1", "1.", "-1", " 1"} {
+		if err := validateBundleVersion(version); err == nil {
+			t.Errorf("validateBundleVersion(%q): expected error", version)
+		}
+	}
+}
+
+func TestUpdateInfoPlistVersions(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "version")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Logf("failed to remove tmp dir: %s", err)
+		}
+	}()
+
+	for _, format := range []int{plist.XMLFormat, plist.BinaryFormat, plist.OpenStepFormat} {
+		formatName := plist.FormatNames[format]
+
+		t.Logf("%s Info.plist", formatName)
+		{
+			pth := filepath.Join(tmpDir, formatName+"-Info.plist")
+			infoPlist := plistutil.PlistData{
+				"CFBundleIdentifier":         "io.bitrise.app",
+				"CFBundleVersion":            "1",
+				"CFBundleShortVersionString": "1.0",
+				"NSHumanReadableCopyright":   "Copyright © 2021 Bitrise\nAll rights reserved.",
+			}
+			if err := infoPlist.WriteToFile(pth, format); err != nil {
+				t.Fatalf("%s: failed to write Info.plist: %s", formatName, err)
+			}
+
+			if err := updateInfoPlistVersions([]string{pth}, "42", "2.1.0"); err != nil {
+				t.Fatalf("%s: unexpected error: %s", formatName, err)
+			}
+
+			updated, updatedFormat, err := plistutil.NewPlistDataAndFormatFromFile(pth)
+			if err != nil {
+				t.Fatalf("%s: failed to read Info.plist: %s", formatName, err)
+			}
+			if updatedFormat != format {
+				t.Errorf("%s: the Info.plist is rewritten in %s format", formatName, plist.FormatNames[updatedFormat])
+			}
+			want := map[string]string{
+				"CFBundleIdentifier":         "io.bitrise.app",
+				"CFBundleVersion":            "42",
+				"CFBundleShortVersionString": "2.1.0",
+				"NSHumanReadableCopyright":   "Copyright © 2021 Bitrise\nAll rights reserved.",
+			}
+			for key, value := range want {
+				if got, _ := updated.GetString(key); got != value {
+					t.Errorf("%s: %s = %q, want %q", formatName, key, got, value)
+				}
+			}
+		}
+	}
+
+	t.Log("only the set versions are updated")
+	{
+		pth := filepath.Join(tmpDir, "Info.plist")
+		infoPlist := plistutil.PlistData{"CFBundleVersion": "1", "CFBundleShortVersionString": "1.0"}
+		if err := infoPlist.WriteToFile(pth, plist.XMLFormat); err != nil {
+			t.Fatalf("failed to write Info.plist: %s", err)
+		}
+
+		if err := updateInfoPlistVersions([]string{pth}, "42", ""); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		updated, err := plistutil.NewPlistDataFromFile(pth)
+		if err != nil {
+			t.Fatalf("failed to read Info.plist: %s", err)
+		}
+		if buildNumber, _ := updated.GetString("CFBundleVersion"); buildNumber != "42" {
+			t.Errorf("CFBundleVersion = %q, want 42", buildNumber)
+		}
+		if version, _ := updated.GetString("CFBundleShortVersionString"); version != "1.0" {
+			t.Errorf("CFBundleShortVersionString = %q, want 1.0", version)
+		}
+	}
+
+	t.Log("missing Info.plist")
+	{
+		if err := updateInfoPlistVersions([]string{filepath.Join(tmpDir, "Missing-Info.plist")}, "42", ""); err == nil {
+			t.Errorf("expected error for the missing Info.plist")
+		}
+	}
+}
+
+func TestVerifyArchivedVersions(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "version")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Logf("failed to remove tmp dir: %s", err)
+		}
+	}()
+
+	archive, err := xcarchive.NewMacosArchive(createTestArchive(t, tmpDir, "App", "42", "2.1.0"))
+	if err != nil {
+		t.Fatalf("failed to open archive: %s", err)
+	}
+
+	t.Log("archived versions match")
+	{
+		for _, versions := range [][2]string{{"42", "2.1.0"}, {"42", ""}, {"", "2.1.0"}, {"", ""}} {
+			if err := verifyArchivedVersions(archive, versions[0], versions[1]); err != nil {
+				t.Errorf("%q: unexpected error: %s", versions, err)
+			}
+		}
+	}
+
+	t.Log("archived versions do not match")
+	{
+		err := verifyArchivedVersions(archive, "43", "2.2.0")
+		want := "io.bitrise.app: CFBundleVersion is 42 instead of 43, CFBundleShortVersionString is 2.1.0 instead of 2.2.0"
+		if err == nil || err.Error() != want {
+			t.Errorf("got error %v, want %s", err, want)
+		}
+	}
+
+	t.Log("mismatching app extension is only reported")
+	{
+		archive.Application.Extensions[0].InfoPlist["CFBundleVersion"] = "41"
+		if err := verifyArchivedVersions(archive, "42", ""); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/sliceutil"
+)
+
+// stepManagedOptions are the xcodebuild options set by the step itself,
+// these can not be overridden by the xcodebuild_options input.
+var stepManagedOptions = []string{
+	"-project",
+	"-workspace",
+	"-scheme",
+	"-configuration",
+	"-archivePath",
+	"-exportArchive",
+	"-exportPath",
+	"-exportOptionsPlist",
+}
+
+// valueOptions are the xcodebuild options followed by a value, the rest of the options are flags.
+var valueOptions = []string{
+	"-project",
+	"-target",
+	"-workspace",
+	"-scheme",
+	"-configuration",
+	"-xcconfig",
+	"-arch",
+	"-sdk",
+	"-toolchain",
+	"-destination",
+	"-destination-timeout",
+	"-jobs",
+	"-derivedDataPath",
+	"-archivePath",
+	"-resultBundlePath",
+	"-resultBundleVersion",
+	"-clonedSourcePackagesDirPath",
+	"-packageCachePath",
+	"-exportPath",
+	"-exportOptionsPlist",
+	"-exportLanguage",
+	"-localizationPath",
+	"-xctestrun",
+	"-testPlan",
+	"-only-testing",
+	"-skip-testing",
+	"-only-test-configuration",
+	"-skip-test-configuration",
+	"-testLanguage",
+	"-testRegion",
+	"-test-iterations",
+	"-enableCodeCoverage",
+	"-enableAddressSanitizer",
+	"-enableThreadSanitizer",
+	"-enableUndefinedBehaviorSanitizer",
+	"-parallel-testing-enabled",
+	"-parallel-testing-worker-count",
+	"-maximum-parallel-testing-workers",
+	"-maximum-concurrent-test-device-destinations",
+	"-maximum-concurrent-test-simulator-destinations",
+	"-authenticationKeyPath",
+	"-authenticationKeyID",
+	"-authenticationKeyIssuerID",
+	"-scmProvider",
+}
+
+// buildSettingKeyRegexp matches build setting keys, including conditional ones like: OTHER_LDFLAGS[arch=x86_64]
+var buildSettingKeyRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\[[^\[\]]+\])*$`)
+
+// splitShellArgs splits the given string into arguments, following the POSIX shell's quoting rules:
+// single quotes preserve every character, double quotes allow escaping \, ", $, ` and newline,
+// unquoted backslash escapes the next character.
+func splitShellArgs(str string) ([]string, error) {
+	args := []string{}
+
+	var arg []rune
+	inArg := false
+	inSingleQuote := false
+	inDoubleQuote := false
+	isEscaped := false
+
+	for _, r := range str {
+		switch {
+		case isEscaped:
+			if inDoubleQuote && !strings.ContainsRune("\\\"$`\n", r) {
+				arg = append(arg, '\\')
+			}
+			if r != '\n' {
+				arg = append(arg, r)
+				inArg = true
+			}
+			isEscaped = false
+		case inSingleQuote:
+			if r == '\'' {
+				inSingleQuote = false
+			} else {
+				arg = append(arg, r)
+			}
+		case inDoubleQuote:
+			if r == '"' {
+				inDoubleQuote = false
+			} else if r == '\\' {
+				isEscaped = true
+			} else {
+				arg = append(arg, r)
+			}
+		case r == '\\':
+			isEscaped = true
+		case r == '\'':
+			inSingleQuote = true
+			inArg = true
+		case r == '"':
+			inDoubleQuote = true
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, string(arg))
+				arg = nil
+				inArg = false
+			}
+		default:
+			arg = append(arg, r)
+			inArg = true
+		}
+	}
+
+	if isEscaped {
+		return nil, fmt.Errorf("unterminated escape sequence in: %s", str)
+	}
+	if inSingleQuote || inDoubleQuote {
+		return nil, fmt.Errorf("unterminated quoted string in: %s", str)
+	}
+	if inArg {
+		args = append(args, string(arg))
+	}
+
+	return args, nil
+}
+
+// parseXcodebuildOptions parses the xcodebuild_options input and fails if it contains any option managed by the step,
+// the -resultBundlePath is only managed by the step if it captures the result bundle (useResultBundle).
+func parseXcodebuildOptions(options string, useResultBundle bool) ([]string, error) {
+	args, err := splitShellArgs(options)
+	if err != nil {
+		return nil, err
+	}
+
+	managedOptions := stepManagedOptions
+	if useResultBundle {
+		managedOptions = append(append([]string{}, stepManagedOptions...), "-resultBundlePath")
+	}
+
+	isOptionValue := false
+	for _, arg := range args {
+		if isOptionValue {
+			isOptionValue = false
+			continue
+		}
+
+		if strings.HasPrefix(arg, "-") {
+			if sliceutil.IsStringInSlice(arg, managedOptions) {
+				return nil, fmt.Errorf("%s is set by the step, use the related step input instead", arg)
+			}
+			isOptionValue = sliceutil.IsStringInSlice(arg, valueOptions)
+			continue
+		}
+
+		// positional arguments: the build actions and the KEY=VALUE build settings
+		if arg == "archive" {
+			return nil, fmt.Errorf("the archive build action is set by the step")
+		}
+	}
+
+	return args, nil
+}
+
+// customOptionValue returns the value of the given option (-option value) of the custom xcodebuild options.
+func customOptionValue(customOptions []string, option string) string {
+	for i, arg := range customOptions {
+		if arg == option && i+1 < len(customOptions) {
+			return customOptions[i+1]
+		}
+	}
+	return ""
+}
+
+// splitBuildSetting splits a KEY=VALUE build setting line,
+// the = characters of the conditional part of the key (KEY[sdk=macosx*]) are not treated as separator.
+func splitBuildSetting(line string) (string, string, bool) {
+	depth := 0
+	for i, r := range line {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '=':
+			if depth == 0 {
+				return strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:]), true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// parseXcodebuildBuildSettings parses the newline separated KEY=VALUE list of the xcodebuild_build_settings input
+// and returns the settings in the form xcodebuild expects them: KEY=VALUE.
+func parseXcodebuildBuildSettings(settings string) ([]string, error) {
+	buildSettings := []string{}
+	seen := map[string]bool{}
+
+	scanner := bufio.NewScanner(strings.NewReader(settings))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := splitBuildSetting(line)
+		if !ok {
+			return nil, fmt.Errorf("invalid build setting (%s), expected format: KEY=VALUE", line)
+		}
+		if !buildSettingKeyRegexp.MatchString(key) {
+			return nil, fmt.Errorf("invalid build setting key: %s", key)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("build setting (%s) is specified multiple times", key)
+		}
+		seen[key] = true
+
+		buildSettings = append(buildSettings, fmt.Sprintf("%s=%s", key, value))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return buildSettings, nil
+}
+
+// buildSettingKey returns the key of a KEY=VALUE build setting.
+func buildSettingKey(buildSetting string) string {
+	key, _, _ := splitBuildSetting(buildSetting)
+	return key
+}
+
+// validateForcedBuildSettings fails if a build setting is both forced by a force_* (or versioning) input and set by the xcodebuild_build_settings input.
+func validateForcedBuildSettings(configs ConfigsModel, buildSettings []string) error {
+	forcedSettings := map[string]string{
+		"DEVELOPMENT_TEAM":               configs.ForceTeamID,
+		"CODE_SIGN_IDENTITY":             configs.ForceCodeSignIdentity,
+		"PROVISIONING_PROFILE_SPECIFIER": configs.ForceProvisioningProfileSpecifier,
+		"PROVISIONING_PROFILE":           configs.ForceProvisioningProfile,
+	}
+	if configs.VersionUpdateMethod == versionUpdateMethodBuildSettings {
+		forcedSettings["CURRENT_PROJECT_VERSION"] = configs.BuildNumber
+		forcedSettings["MARKETING_VERSION"] = configs.MarketingVersion
+	}
+
+	for _, buildSetting := range buildSettings {
+		key := buildSettingKey(buildSetting)
+		if forced := forcedSettings[key]; forced != "" {
+			return fmt.Errorf("build setting (%s) is also set by the step's force archive codesign or versioning inputs", key)
+		}
+	}
+
+	return nil
+}
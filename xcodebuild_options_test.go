@@ -0,0 +1,172 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitShellArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		str     string
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty", str: "", want: []string{}},
+		{name: "whitespace separated", str: " -quiet\t-allowProvisioningUpdates \n-jobs 4 ", want: []string{"-quiet", "-allowProvisioningUpdates", "-jobs", "4"}},
+		{name: "single quotes preserve every character", str: `-xcconfig 'My Config/a\b $HOME "x".xcconfig'`, want: []string{"-xcconfig", `My Config/a\b $HOME "x".xcconfig`}},
+		{name: "double quotes with escapes", str: `"a \"b\" \$c \d"`, want: []string{`a "b" $c \d`}},
+		{name: "unquoted escape", str: `My\ Project.xcodeproj \'x`, want: []string{"My Project.xcodeproj", "'x"}},
+		{name: "escaped newline joins the lines", str: "-destination \\\n'generic/platform=macOS'", want: []string{"-destination", "generic/platform=macOS"}},
+		{name: "empty quoted argument", str: `-a '' ""`, want: []string{"-a", "", ""}},
+		{name: "adjacent quoted parts", str: `OTHER_SWIFT_FLAGS='-D'"DEBUG"`, want: []string{"OTHER_SWIFT_FLAGS=-DDEBUG"}},
+		{name: "unterminated single quote", str: `-a 'b`, wantErr: true},
+		{name: "unterminated double quote", str: `-a "b`, wantErr: true},
+		{name: "unterminated escape", str: `-a b\`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := splitShellArgs(tt.str)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got: %q", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseXcodebuildOptions(t *testing.T) {
+	got, err := parseXcodebuildOptions(`-destination 'generic/platform=macOS' -derivedDataPath "./Derived Data"`, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []string{"-destination", "generic/platform=macOS", "-derivedDataPath", "./Derived Data"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for _, options := range []string{
+		"-xcconfig archive",
+		"-quiet -xcconfig archive -sdk macosx",
+		"-destination archive",
+		"clean",
+		"-quiet clean ONLY_ACTIVE_ARCH=NO",
+		"ARCHIVE=archive",
+	} {
+		if _, err := parseXcodebuildOptions(options, true); err != nil {
+			t.Errorf("unexpected error for %s: %s", options, err)
+		}
+	}
+
+	t.Log("-resultBundlePath is only managed by the step if it captures the result bundle")
+	{
+		got, err := parseXcodebuildOptions("-resultBundlePath ./App.xcresult", false)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		want := []string{"-resultBundlePath", "./App.xcresult"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		if _, err := parseXcodebuildOptions("-resultBundlePath ./App.xcresult", true); err == nil {
+			t.Errorf("expected -resultBundlePath to be rejected")
+		}
+	}
+
+	for _, options := range []string{
+		"-scheme Other",
+		"-quiet -project App.xcodeproj",
+		"-workspace App.xcworkspace",
+		"-configuration Debug",
+		"-archivePath ./App.xcarchive",
+		"-exportArchive",
+		"-exportPath ./export",
+		"-exportOptionsPlist ./options.plist",
+		"clean archive",
+		"archive",
+		"-quiet archive",
+		"-xcconfig ./Release.xcconfig archive",
+		`'-scheme' Other`,
+	} {
+		if _, err := parseXcodebuildOptions(options, false); err == nil {
+			t.Errorf("expected the step managed option to be rejected: %s", options)
+		}
+	}
+
+	if _, err := parseXcodebuildOptions(`-xcconfig 'unterminated`, true); err == nil {
+		t.Errorf("expected error for unterminated quote")
+	}
+}
+
+func TestCustomOptionValue(t *testing.T) {
+	options := []string{"-quiet", "-derivedDataPath", "./DerivedData", "-clonedSourcePackagesDirPath"}
+
+	if got := customOptionValue(options, "-derivedDataPath"); got != "./DerivedData" {
+		t.Errorf("got %q, want ./DerivedData", got)
+	}
+	if got := customOptionValue(options, "-clonedSourcePackagesDirPath"); got != "" {
+		t.Errorf("option without value: got %q, want empty", got)
+	}
+	if got := customOptionValue(options, "-xcconfig"); got != "" {
+		t.Errorf("missing option: got %q, want empty", got)
+	}
+}
+
+func TestParseXcodebuildBuildSettings(t *testing.T) {
+	got, err := parseXcodebuildBuildSettings(`
+# comment
+ONLY_ACTIVE_ARCH = NO
+OTHER_LDFLAGS[arch=x86_64]=-ObjC
+GCC_PREPROCESSOR_DEFINITIONS[sdk=macosx*][config=Release] = A=1 B=2
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []string{"ONLY_ACTIVE_ARCH=NO", "OTHER_LDFLAGS[arch=x86_64]=-ObjC", "GCC_PREPROCESSOR_DEFINITIONS[sdk=macosx*][config=Release]=A=1 B=2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for _, settings := range []string{
+		"ONLY_ACTIVE_ARCH",
+		"1KEY=value",
+		"KEY WITH SPACE=value",
+		"KEY=a\nKEY=b",
+	} {
+		if _, err := parseXcodebuildBuildSettings(settings); err == nil {
+			t.Errorf("expected error for: %q", settings)
+		}
+	}
+}
+
+func TestValidateForcedBuildSettings(t *testing.T) {
+	configs := ConfigsModel{
+		ForceTeamID:         "ABCDE12345",
+		VersionUpdateMethod: versionUpdateMethodBuildSettings,
+		BuildNumber:         "42",
+	}
+
+	if err := validateForcedBuildSettings(configs, []string{"ONLY_ACTIVE_ARCH=NO", "CODE_SIGN_IDENTITY=-"}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := validateForcedBuildSettings(configs, []string{"DEVELOPMENT_TEAM=OTHER"}); err == nil {
+		t.Errorf("expected error for the forced DEVELOPMENT_TEAM")
+	}
+	if err := validateForcedBuildSettings(configs, []string{"CURRENT_PROJECT_VERSION=1"}); err == nil {
+		t.Errorf("expected error for the build number set by the versioning inputs")
+	}
+
+	configs.VersionUpdateMethod = versionUpdateMethodInfoPlist
+	if err := validateForcedBuildSettings(configs, []string{"CURRENT_PROJECT_VERSION=1"}); err != nil {
+		t.Errorf("the info_plist version update method does not force build settings, got: %s", err)
+	}
+}
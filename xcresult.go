@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-tools/go-steputils/output"
+	"github.com/bitrise-tools/go-xcode/plistutil"
+)
+
+// xcresultMinXcodeMajorVersion is the first Xcode version, which ships the xcresulttool.
+const xcresultMinXcodeMajorVersion = 11
+
+// xcresulttool runs `xcrun xcresulttool` with the given arguments and returns its stdout,
+// tests can replace it to avoid depending on Xcode.
+var xcresulttool = func(args ...string) (string, error) {
+	cmd := command.New("xcrun", append([]string{"xcresulttool"}, args...)...)
+	out, err := cmd.RunAndReturnTrimmedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s failed, error: %s", cmd.PrintableCommandArgs(), err)
+	}
+	return out, nil
+}
+
+// xcresultIssue ...
+type xcresultIssue struct {
+	Type     string
+	Message  string
+	Location string
+}
+
+// String ...
+func (issue xcresultIssue) String() string {
+	str := issue.Message
+	if issue.Type != "" {
+		str = issue.Type + ": " + str
+	}
+	if issue.Location != "" {
+		str += " (" + issue.Location + ")"
+	}
+	return str
+}
+
+// xcresultSummary is the build result stored in a result bundle.
+type xcresultSummary struct {
+	FormatVersion        string
+	Status               string
+	ErrorCount           int
+	WarningCount         int
+	AnalyzerWarningCount int
+	Errors               []xcresultIssue
+}
+
+// readXcresultFormatVersion reads the format version from the result bundle's Info.plist.
+func readXcresultFormatVersion(xcresultPth string) (string, error) {
+	infoPlistPth := filepath.Join(xcresultPth, "Info.plist")
+	if exist, err := pathutil.IsPathExists(infoPlistPth); err != nil {
+		return "", fmt.Errorf("failed to check if Info.plist exists at: %s, error: %s", infoPlistPth, err)
+	} else if !exist {
+		return "", fmt.Errorf("Info.plist not exists at: %s", infoPlistPth)
+	}
+
+	infoPlist, err := plistutil.NewPlistDataFromFile(infoPlistPth)
+	if err != nil {
+		return "", err
+	}
+
+	version, found := infoPlist.GetMapStringInterface("version")
+	if !found {
+		return "", fmt.Errorf("no version found in: %s", infoPlistPth)
+	}
+
+	major, _ := version.GetUInt64("major")
+	minor, _ := version.GetUInt64("minor")
+	return fmt.Sprintf("%d.%d", major, minor), nil
+}
+
+// xcresultBuildResults is the output of `xcresulttool get build-results` (Xcode 16 and above).
+type xcresultBuildResults struct {
+	Status               string `json:"status"`
+	ErrorCount           int    `json:"errorCount"`
+	WarningCount         int    `json:"warningCount"`
+	AnalyzerWarningCount int    `json:"analyzerWarningCount"`
+	Errors               []struct {
+		IssueType string `json:"issueType"`
+		Message   string `json:"message"`
+		SourceURL string `json:"sourceURL"`
+	} `json:"errors"`
+}
+
+func parseXcresultBuildResults(out string) (xcresultSummary, error) {
+	var results xcresultBuildResults
+	if err := json.Unmarshal([]byte(out), &results); err != nil {
+		return xcresultSummary{}, fmt.Errorf("failed to parse build results, error: %s", err)
+	}
+
+	summary := xcresultSummary{
+		Status:               results.Status,
+		ErrorCount:           results.ErrorCount,
+		WarningCount:         results.WarningCount,
+		AnalyzerWarningCount: results.AnalyzerWarningCount,
+	}
+	for _, e := range results.Errors {
+		summary.Errors = append(summary.Errors, xcresultIssue{
+			Type:     e.IssueType,
+			Message:  e.Message,
+			Location: e.SourceURL,
+		})
+	}
+	return summary, nil
+}
+
+// xcresultValue is the wrapper of every value in the legacy xcresulttool json output.
+type xcresultValue struct {
+	Value string `json:"_value"`
+}
+
+func (v xcresultValue) int() int {
+	i, err := strconv.Atoi(v.Value)
+	if err != nil {
+		return 0
+	}
+	return i
+}
+
+// xcresultActionsInvocationRecord is the root object of the legacy `xcresulttool get --format json` output.
+type xcresultActionsInvocationRecord struct {
+	Actions struct {
+		Values []struct {
+			BuildResult struct {
+				Status xcresultValue `json:"status"`
+			} `json:"buildResult"`
+			ActionResult struct {
+				Status xcresultValue `json:"status"`
+			} `json:"actionResult"`
+		} `json:"_values"`
+	} `json:"actions"`
+	Issues struct {
+		ErrorSummaries struct {
+			Values []struct {
+				IssueType                           xcresultValue `json:"issueType"`
+				Message                             xcresultValue `json:"message"`
+				DocumentLocationInCreatingWorkspace struct {
+					URL xcresultValue `json:"url"`
+				} `json:"documentLocationInCreatingWorkspace"`
+			} `json:"_values"`
+		} `json:"errorSummaries"`
+	} `json:"issues"`
+	Metrics struct {
+		ErrorCount           xcresultValue `json:"errorCount"`
+		WarningCount         xcresultValue `json:"warningCount"`
+		AnalyzerWarningCount xcresultValue `json:"analyzerWarningCount"`
+	} `json:"metrics"`
+}
+
+func parseXcresultActionsInvocationRecord(out string) (xcresultSummary, error) {
+	var record xcresultActionsInvocationRecord
+	if err := json.Unmarshal([]byte(out), &record); err != nil {
+		return xcresultSummary{}, fmt.Errorf("failed to parse actions invocation record, error: %s", err)
+	}
+
+	summary := xcresultSummary{
+		ErrorCount:           record.Metrics.ErrorCount.int(),
+		WarningCount:         record.Metrics.WarningCount.int(),
+		AnalyzerWarningCount: record.Metrics.AnalyzerWarningCount.int(),
+	}
+
+	for _, action := range record.Actions.Values {
+		status := action.BuildResult.Status.Value
+		if status == "" {
+			status = action.ActionResult.Status.Value
+		}
+		if status != "" {
+			summary.Status = status
+		}
+	}
+
+	for _, e := range record.Issues.ErrorSummaries.Values {
+		summary.Errors = append(summary.Errors, xcresultIssue{
+			Type:     e.IssueType.Value,
+			Message:  e.Message.Value,
+			Location: e.DocumentLocationInCreatingWorkspace.URL.Value,
+		})
+	}
+	return summary, nil
+}
+
+// readXcresultSummary reads the build status, issue counts and error messages from the given result bundle.
+func readXcresultSummary(xcresultPth string) (xcresultSummary, error) {
+	formatVersion, err := readXcresultFormatVersion(xcresultPth)
+	if err != nil {
+		return xcresultSummary{}, err
+	}
+
+	var summary xcresultSummary
+	if out, err := xcresulttool("get", "build-results", "--path", xcresultPth); err == nil {
+		summary, err = parseXcresultBuildResults(out)
+		if err != nil {
+			return xcresultSummary{}, err
+		}
+	} else {
+		log.Debugf("xcresulttool build-results not available, falling back to the legacy format: %s", err)
+
+		out, err := xcresulttool("get", "--legacy", "--format", "json", "--path", xcresultPth)
+		if err != nil {
+			// xcresulttool versions before Xcode 16 do not know the --legacy flag
+			out, err = xcresulttool("get", "--format", "json", "--path", xcresultPth)
+			if err != nil {
+				return xcresultSummary{}, err
+			}
+		}
+
+		summary, err = parseXcresultActionsInvocationRecord(out)
+		if err != nil {
+			return xcresultSummary{}, err
+		}
+	}
+
+	summary.FormatVersion = formatVersion
+	return summary, nil
+}
+
+// xcresultFailureReason prints the result bundle's summary and returns its error messages,
+// to be appended to the step's failure reason.
+func xcresultFailureReason(xcresultPth string) string {
+	if exist, err := pathutil.IsDirExists(xcresultPth); err != nil || !exist {
+		return ""
+	}
+
+	summary, err := readXcresultSummary(xcresultPth)
+	if err != nil {
+		log.Warnf("Failed to read result bundle (%s), error: %s", xcresultPth, err)
+		return ""
+	}
+
+	fmt.Println()
+	log.Infof("Result bundle summary:")
+	log.Printf("- format version: %s", summary.FormatVersion)
+	log.Printf("- status: %s", summary.Status)
+	log.Printf("- errors: %d", summary.ErrorCount)
+	log.Printf("- warnings: %d", summary.WarningCount)
+	log.Printf("- analyzer warnings: %d", summary.AnalyzerWarningCount)
+
+	messages := []string{}
+	for _, issue := range summary.Errors {
+		log.Errorf("%s", issue)
+		messages = append(messages, issue.Message)
+	}
+	fmt.Println()
+
+	if len(messages) == 0 {
+		return ""
+	}
+	return "\n" + strings.Join(messages, "\n")
+}
+
+// exportXcresult zips the result bundle (if it was created) and exports the zip path.
+// Returns the exported zip path, or empty string if nothing was exported.
+func exportXcresult(xcresultPth, zipPth, envKey string) string {
+	if exist, err := pathutil.IsDirExists(xcresultPth); err != nil {
+		log.Warnf("Failed to check if result bundle exists at: %s, error: %s", xcresultPth, err)
+		return ""
+	} else if !exist {
+		return ""
+	}
+
+	if err := output.ZipAndExportOutput(xcresultPth, zipPth, envKey); err != nil {
+		log.Warnf("Failed to export %s, error: %s", envKey, err)
+		return ""
+	}
+
+	log.Donef("The result bundle zip path is now available in the Environment Variable: %s (value: %s)", envKey, zipPth)
+	return zipPth
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseXcresultBuildResults(t *testing.T) {
+	got, err := parseXcresultBuildResults(testXcresultBuildResultsOut)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := xcresultSummary{
+		Status:               "failed",
+		ErrorCount:           2,
+		WarningCount:         3,
+		AnalyzerWarningCount: 1,
+		Errors: []xcresultIssue{
+			{
+				Type:     "Swift Compiler Error",
+				Message:  "Cannot find 'undefinedSymbol' in scope",
+				Location: "file:///Users/vagrant/git/App/AppDelegate.swift#EndingLineNumber=12&StartingLineNumber=12",
+			},
+			{
+				Type:    "Signing Error",
+				Message: "No signing certificate \"Developer ID Application\" found",
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if _, err := parseXcresultBuildResults("Error: unknown subcommand build-results"); err == nil {
+		t.Errorf("expected error for non json output")
+	}
+}
+
+func TestParseXcresultActionsInvocationRecord(t *testing.T) {
+	got, err := parseXcresultActionsInvocationRecord(testXcresultActionsInvocationRecordOut)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := xcresultSummary{
+		Status:       "failed",
+		ErrorCount:   1,
+		WarningCount: 4,
+		Errors: []xcresultIssue{
+			{
+				Type:     "Swift Compiler Error",
+				Message:  "Cannot find 'undefinedSymbol' in scope",
+				Location: "file:///Users/vagrant/git/App/AppDelegate.swift#EndingLineNumber=12&StartingLineNumber=12",
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// an archive action without build result stores its status in the action result
+	got, err = parseXcresultActionsInvocationRecord(`{"actions":{"_values":[{"actionResult":{"status":{"_value":"succeeded"}}}]}}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got.Status != "succeeded" || got.ErrorCount != 0 || len(got.Errors) != 0 {
+		t.Errorf("unexpected summary: %+v", got)
+	}
+
+	if _, err := parseXcresultActionsInvocationRecord("{"); err == nil {
+		t.Errorf("expected error for invalid json")
+	}
+}
+
+func TestReadXcresultSummary(t *testing.T) {
+	origXcresulttool := xcresulttool
+	defer func() { xcresulttool = origXcresulttool }()
+
+	tmpDir, err := ioutil.TempDir("", "xcresult")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Logf("failed to remove tmp dir: %s", err)
+		}
+	}()
+
+	xcresultPth := filepath.Join(tmpDir, "App.xcresult")
+	if err := os.MkdirAll(xcresultPth, 0755); err != nil {
+		t.Fatalf("failed to create result bundle: %s", err)
+	}
+
+	t.Log("missing Info.plist")
+	{
+		xcresulttool = func(args ...string) (string, error) {
+			t.Errorf("xcresulttool should not run without Info.plist, args: %v", args)
+			return "", nil
+		}
+		if _, err := readXcresultSummary(xcresultPth); err == nil {
+			t.Errorf("expected error for missing Info.plist")
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(xcresultPth, "Info.plist"), []byte(testXcresultInfoPlistContent), 0644); err != nil {
+		t.Fatalf("failed to write Info.plist: %s", err)
+	}
+
+	t.Log("Xcode 16 and above: build-results")
+	{
+		calls := [][]string{}
+		xcresulttool = func(args ...string) (string, error) {
+			calls = append(calls, args)
+			return testXcresultBuildResultsOut, nil
+		}
+
+		summary, err := readXcresultSummary(xcresultPth)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if summary.FormatVersion != "3.53" || summary.Status != "failed" || summary.ErrorCount != 2 {
+			t.Errorf("unexpected summary: %+v", summary)
+		}
+		wantCalls := [][]string{{"get", "build-results", "--path", xcresultPth}}
+		if !reflect.DeepEqual(calls, wantCalls) {
+			t.Errorf("got calls %q, want %q", calls, wantCalls)
+		}
+	}
+
+	t.Log("Xcode 15: falls back to the format without the --legacy flag")
+	{
+		calls := [][]string{}
+		xcresulttool = func(args ...string) (string, error) {
+			calls = append(calls, args)
+			cmd := strings.Join(args, " ")
+			if strings.Contains(cmd, "build-results") || strings.Contains(cmd, "--legacy") {
+				return "", fmt.Errorf("xcrun xcresulttool %s failed, error: exit status 64", cmd)
+			}
+			return testXcresultActionsInvocationRecordOut, nil
+		}
+
+		summary, err := readXcresultSummary(xcresultPth)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if summary.FormatVersion != "3.53" || summary.Status != "failed" || summary.WarningCount != 4 {
+			t.Errorf("unexpected summary: %+v", summary)
+		}
+		wantCalls := [][]string{
+			{"get", "build-results", "--path", xcresultPth},
+			{"get", "--legacy", "--format", "json", "--path", xcresultPth},
+			{"get", "--format", "json", "--path", xcresultPth},
+		}
+		if !reflect.DeepEqual(calls, wantCalls) {
+			t.Errorf("got calls %q, want %q", calls, wantCalls)
+		}
+	}
+
+	t.Log("xcresulttool fails")
+	{
+		xcresulttool = func(args ...string) (string, error) {
+			return "", fmt.Errorf("xcrun: error: unable to find utility \"xcresulttool\"")
+		}
+		if _, err := readXcresultSummary(xcresultPth); err == nil {
+			t.Errorf("expected error if xcresulttool fails")
+		}
+	}
+
+	t.Log("invalid build-results output")
+	{
+		xcresulttool = func(args ...string) (string, error) {
+			return "not json", nil
+		}
+		if _, err := readXcresultSummary(xcresultPth); err == nil {
+			t.Errorf("expected error for invalid build-results output")
+		}
+	}
+}
+
+const testXcresultInfoPlistContent = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>dateCreated</key>
+	<date>2024-10-01T10:00:00Z</date>
+	<key>externalLocations</key>
+	<array/>
+	<key>rootId</key>
+	<dict>
+		<key>hash</key>
+		<string>0~8l3E2KJK9sVjLmXcWm9ZpQ==</string>
+	</dict>
+	<key>storage</key>
+	<dict>
+		<key>backend</key>
+		<string>fileBacked2</string>
+		<key>compression</key>
+		<string>standard</string>
+	</dict>
+	<key>version</key>
+	<dict>
+		<key>major</key>
+		<integer>3</integer>
+		<key>minor</key>
+		<integer>53</integer>
+	</dict>
+</dict>
+</plist>
+`
+
+const testXcresultBuildResultsOut = `{
+  "actionTitle" : "Archive \"App\"",
+  "analyzerWarningCount" : 1,
+  "analyzerWarnings" : [
+
+  ],
+  "destination" : {
+    "architecture" : "arm64",
+    "deviceId" : "00006000-000A1C2E3E08801E",
+    "deviceName" : "My Mac",
+    "modelName" : "My Mac",
+    "osVersion" : "14.6.1",
+    "platform" : "macOS"
+  },
+  "endTime" : 1727776860.123,
+  "errorCount" : 2,
+  "errors" : [
+    {
+      "issueType" : "Swift Compiler Error",
+      "message" : "Cannot find 'undefinedSymbol' in scope",
+      "sourceURL" : "file:///Users/vagrant/git/App/AppDelegate.swift#EndingLineNumber=12&StartingLineNumber=12",
+      "targetName" : "App"
+    },
+    {
+      "issueType" : "Signing Error",
+      "message" : "No signing certificate \"Developer ID Application\" found",
+      "targetName" : "App"
+    }
+  ],
+  "startTime" : 1727776800.456,
+  "status" : "failed",
+  "warningCount" : 3,
+  "warnings" : [
+
+  ]
+}`
+
+const testXcresultActionsInvocationRecordOut = `{
+  "_type" : {
+    "_name" : "ActionsInvocationRecord"
+  },
+  "actions" : {
+    "_type" : {
+      "_name" : "Array"
+    },
+    "_values" : [
+      {
+        "_type" : {
+          "_name" : "ActionRecord"
+        },
+        "actionResult" : {
+          "_type" : {
+            "_name" : "ActionResult"
+          },
+          "status" : {
+            "_type" : {
+              "_name" : "String"
+            },
+            "_value" : "notRequested"
+          }
+        },
+        "buildResult" : {
+          "_type" : {
+            "_name" : "ActionResult"
+          },
+          "status" : {
+            "_type" : {
+              "_name" : "String"
+            },
+            "_value" : "failed"
+          }
+        },
+        "schemeCommandName" : {
+          "_type" : {
+            "_name" : "String"
+          },
+          "_value" : "Archive"
+        }
+      }
+    ]
+  },
+  "issues" : {
+    "_type" : {
+      "_name" : "ResultIssueSummaries"
+    },
+    "errorSummaries" : {
+      "_type" : {
+        "_name" : "Array"
+      },
+      "_values" : [
+        {
+          "_type" : {
+            "_name" : "IssueSummary"
+          },
+          "documentLocationInCreatingWorkspace" : {
+            "_type" : {
+              "_name" : "DocumentLocation"
+            },
+            "concreteTypeName" : {
+              "_type" : {
+                "_name" : "String"
+              },
+              "_value" : "DVTTextDocumentLocation"
+            },
+            "url" : {
+              "_type" : {
+                "_name" : "String"
+              },
+              "_value" : "file:///Users/vagrant/git/App/AppDelegate.swift#EndingLineNumber=12&StartingLineNumber=12"
+            }
+          },
+          "issueType" : {
+            "_type" : {
+              "_name" : "String"
+            },
+            "_value" : "Swift Compiler Error"
+          },
+          "message" : {
+            "_type" : {
+              "_name" : "String"
+            },
+            "_value" : "Cannot find 'undefinedSymbol' in scope"
+          }
+        }
+      ]
+    }
+  },
+  "metrics" : {
+    "_type" : {
+      "_name" : "ResultMetrics"
+    },
+    "errorCount" : {
+      "_type" : {
+        "_name" : "Int"
+      },
+      "_value" : "1"
+    },
+    "warningCount" : {
+      "_type" : {
+        "_name" : "Int"
+      },
+      "_value" : "4"
+    }
+  }
+}`